@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// How much each part of a bestmatch score counts towards the total
+const (
+	bestMatchTokenWeight      = 60.0 //The share of query words found in a result's name or lead creator
+	bestMatchCoverageWeight   = 20.0 //The share of a result's name covered by the query
+	bestMatchExactWeight      = 30.0 //The query is exactly a result's name, or its name with its lead creator
+	bestMatchPopularityWeight = 10.0 //A result's popularity on its provider
+	bestMatchProviderWeight   = 5.0  //A result's provider is preferred
+	bestMatchRankWeight       = 5.0  //A result's rank within its type, as ordered by its provider
+)
+
+// Type hints that restrict a bestmatch to one object type, ex: bestmatch:creator:daft punk
+var bestMatchHints = map[string]bool{
+	"creator": true,
+	"album":   true,
+	"stream":  true,
+}
+
+// BestMatchScore holds how well a search result matches a bestmatch query, and what made up its score
+type BestMatchScore struct {
+	URI        string  `json:"uri"`
+	Type       string  `json:"type"`
+	Provider   string  `json:"provider"`
+	Name       string  `json:"name"`
+	Score      float64 `json:"score"`      //The total of every part below
+	Tokens     float64 `json:"tokens"`     //From query words found in the result
+	Coverage   float64 `json:"coverage"`   //From result words found in the query
+	Exact      float64 `json:"exact"`      //From the query exactly matching the result
+	Popularity float64 `json:"popularity"` //From the result's popularity
+	Preference float64 `json:"preference"` //From the result's provider being preferred
+	Rank       float64 `json:"rank"`       //From the result's rank within its type
+}
+
+// BestMatch searches for a bestmatch query and returns every result scored against it from best to worst
+func (s *Service) BestMatch(query string, params url.Values) ([]*BestMatchScore, *Object) {
+	hint, query := parseBestMatch(query)
+	searchURI := NewQueryURI("search", query)
+	searchURI.Params = params
+	searchResultsObj := GetObjectLive(searchURI.String())
+	if searchResultsObj == nil || searchResultsObj.Type != "search" {
+		return nil, searchResultsObj
+	}
+	searchResults := searchResultsObj.SearchResults()
+
+	scores := make([]*BestMatchScore, 0)
+	words := strings.Fields(normalizeName(query))
+	for _, results := range [][]*Object{searchResults.Streams, searchResults.Creators, searchResults.Albums} {
+		for i := 0; i < len(results); i++ {
+			if results[i] == nil || (hint != "" && results[i].Type != hint) {
+				continue
+			}
+			score := s.scoreBestMatch(words, results[i])
+			if score == nil {
+				continue
+			}
+			score.Rank = bestMatchRankWeight * float64(len(results)-i) / float64(len(results))
+			score.Score = score.Tokens + score.Coverage + score.Exact + score.Popularity + score.Preference + score.Rank
+			scores = append(scores, score)
+		}
+	}
+	//Ties keep the search order, which lists streams first
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	return scores, searchResultsObj
+}
+
+// parseBestMatch splits a type hint from a bestmatch query, if it has one
+func parseBestMatch(query string) (hint, rest string) {
+	if i := strings.Index(query, ":"); i > -1 && bestMatchHints[query[:i]] {
+		return query[:i], strings.TrimSpace(query[i+1:])
+	}
+	return "", query
+}
+
+// scoreBestMatch scores a search result against the words of a query, leaving out its rank and total
+func (s *Service) scoreBestMatch(words []string, obj *Object) *BestMatchScore {
+	score := &BestMatchScore{URI: obj.URI, Type: obj.Type, Provider: obj.Provider}
+	var creators []*Object
+	popularity := 0
+	switch obj.Type {
+	case "creator":
+		creator := obj.Creator()
+		if creator == nil {
+			return nil
+		}
+		score.Name, popularity = creator.Name, creator.Popularity
+	case "album":
+		album := obj.Album()
+		if album == nil {
+			return nil
+		}
+		score.Name, creators, popularity = album.Name, album.Creators, album.Popularity
+	case "stream":
+		stream := obj.Stream()
+		if stream == nil {
+			return nil
+		}
+		score.Name, creators, popularity = stream.Name, stream.Creators, stream.Popularity
+	default:
+		return nil
+	}
+	name := normalizeName(score.Name)
+	creatorName := ""
+	if len(creators) > 0 {
+		if creator := creators[0].Creator(); creator != nil {
+			creatorName = normalizeName(creator.Name)
+		}
+	}
+
+	query := strings.Join(words, " ")
+	nameWords := strings.Fields(name)
+	resultWords := make(map[string]bool)
+	for _, word := range append(strings.Fields(creatorName), nameWords...) {
+		resultWords[word] = true
+	}
+	queryWords := make(map[string]bool)
+	found := 0
+	for i := 0; i < len(words); i++ {
+		queryWords[words[i]] = true
+		if resultWords[words[i]] {
+			found++
+		}
+	}
+	if len(words) > 0 {
+		score.Tokens = bestMatchTokenWeight * float64(found) / float64(len(words))
+	}
+	covered := 0
+	for i := 0; i < len(nameWords); i++ {
+		if queryWords[nameWords[i]] {
+			covered++
+		}
+	}
+	if len(nameWords) > 0 {
+		score.Coverage = bestMatchCoverageWeight * float64(covered) / float64(len(nameWords))
+	}
+	if name != "" && (query == name || (creatorName != "" && (query == creatorName+" "+name || query == name+" "+creatorName))) {
+		score.Exact = bestMatchExactWeight
+	}
+	score.Popularity = bestMatchPopularityWeight * float64(popularity) / 100
+	for i := 0; i < len(s.PreferredProviders); i++ {
+		if s.PreferredProviders[i] == obj.Provider {
+			score.Preference = bestMatchProviderWeight * float64(len(s.PreferredProviders)-i) / float64(len(s.PreferredProviders))
+			break
+		}
+	}
+	return score
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"strings"
+	"strconv"
 )
 
 // GetObject returns an object, either from the cache, or live if possible
 func GetObject(uri string) (obj *Object) {
+	//Serve whatever the cache holds if the object can't be fetched live
+	if parsedURI, err := ParseURI(uri); err == nil && !service.IsHealthy(parsedURI.Provider) || service.IsOffline() {
+		return GetObjectOffline(uri)
+	}
+
 	//Try the cache first, it will update frequently during a live expansion
 	obj = GetObjectCached(uri)
 	if obj != nil {
+		//Serve the stale object while a fresh copy replaces it in the background
+		if obj.Stale && !IsRefreshing(uri) {
+			go func() {
+				if fresh := RefreshObject(uri); fresh != nil && fresh.Type != "error" {
+					fresh.Expand()
+				}
+			}()
+		}
 		return obj
 	}
 
@@ -30,140 +41,213 @@ func GetObjectLive(mediaURI string) (obj *Object) {
 		Error.Println("Cannot get object with empty mediaURI")
 		return nil
 	}
+	uri, err := ParseURI(mediaURI)
+	if err != nil {
+		return NewObjError(err)
+	}
 
-	obj = &Object{URI: mediaURI, Object: &json.RawMessage{}}
-	Trace.Println("Fetching " + mediaURI + " live")
+	obj = &Object{URI: uri.String()}
+	Trace.Println("Fetching " + obj.URI + " live")
 
-	splitURI := strings.Split(mediaURI, ":")
-	switch splitURI[0] {
+	switch uri.Provider {
 	case "bestmatch": //Returns an object that best matches the given search query
-		if len(splitURI) < 2 {
-			return NewObjError("bestmatch: need query")
-		}
-		query := strings.ReplaceAll(splitURI[1], "+", " ")
-		query = strings.ReplaceAll(query, "%20", " ")
-		query = strings.ToLower(query)
-		searchResultsObj := GetObjectLive("search:" + splitURI[1])
-		if searchResultsObj.Type != "search" {
+		if uri.ID == "" {
+			return NewObjError(NewError(ErrBadURI, "", "bestmatch: need query"))
+		}
+		scores, searchResultsObj := service.BestMatch(uri.ID, uri.Params)
+		if len(scores) == 0 && searchResultsObj != nil && searchResultsObj.Type != "search" {
 			return searchResultsObj
 		}
-		searchResults := searchResultsObj.SearchResults()
-		if len(searchResults.Streams) > 0 {
-			return GetObjectLive(searchResults.Streams[0].Stream().URI)
+		if len(scores) > 0 {
+			return GetObjectLive(scores[0].URI)
 		}
-		if len(searchResults.Creators) > 0 {
-			return GetObjectLive(searchResults.Creators[0].Creator().URI)
+		return NewObjError(NewError(ErrNotFound, "", "bestmatch: try a better query"))
+	case "charts": //Returns the most played or downloaded streams, ex: charts:streams?window=week
+		window := uri.Params.Get("window")
+		if window == "" {
+			window = "week"
 		}
-		if len(searchResults.Albums) > 0 {
-			return GetObjectLive(searchResults.Albums[0].Album().URI)
+		entries, err := stats.Chart(uri.ID, window)
+		if err != nil {
+			return NewObjError(err)
 		}
-		return NewObjError("bestmatch: try a better query")
-	case "search": //Main search handler
-		if len(splitURI) < 2 {
-			return NewObjError("search: need query")
-		}
-		query := strings.ReplaceAll(splitURI[1], "+", " ")
-		query = strings.ReplaceAll(query, "%20", " ")
-		query = strings.ToLower(query)
-		obj.URI = "search:" + query
-		results := &ObjectSearchResults{Query: query}
-		for i := 0; i < len(providers); i++ {
-			Trace.Println("Searching for '" + query + "' on " + providers[i])
-			handler := handlers[providers[i]]
-			res, err := handler.Search(query)
-			if err != nil {
-				Error.Printf("Error searching on %s: %v", providers[i], err)
-				continue
-			}
-			if len(res.Creators) > 0 {
-				results.Creators = append(results.Creators, res.Creators...)
-			}
-			if len(res.Albums) > 0 {
-				results.Albums = append(results.Albums, res.Albums...)
-			}
-			if len(res.Streams) > 0 {
-				results.Streams = append(results.Streams, res.Streams...)
+		for i := 0; i < len(entries); i++ {
+			if cached := GetObjectCached(entries[i].Object.URI); cached != nil {
+				entries[i].Object = cached
 			}
 		}
-		obj.Type = "search"
+		obj.Type = "charts"
 		obj.Provider = "libremedia"
-		resultsJSON, err := json.Marshal(results)
-		if err != nil {
-			Error.Printf("Unable to marshal search results: %v\n", err)
-			return NewObjError(fmt.Sprintf("invalid search %s: %v", query, err))
+		obj.Object = &ObjectCharts{Chart: uri.ID, Window: window, Entries: entries}
+		return
+	case "libremedia": //Returns an object saved by libremedia itself, ex: libremedia:playlist:abcdef
+		if uri.Type == "playlist" && uri.ID != "" {
+			return GetPlaylist(uri.ID)
 		}
-
-		if err := obj.Object.UnmarshalJSON(resultsJSON); err != nil {
-			Error.Printf("Unable to unmarshal search results: %v\n", err)
-			return NewObjError(fmt.Sprintf("invalid search %s: %v", query, err))
+		return NewObjError(NewError(ErrBadURI, "libremedia", "unknown object type %s", uri.Type))
+	case "isrc", "upc": //Resolves a standard identifier through every provider that can look it up
+		return GetIdentifierLive(uri)
+	case "search": //Main search handler
+		if uri.ID == "" {
+			return NewObjError(NewError(ErrBadURI, "", "search: need query"))
+		}
+		results := service.Search(uri.ID, searchFilter(uri.Params))
+		obj.Type = "search"
+		obj.Provider = "libremedia"
+		obj.Object = results
+		if results.IsEmpty() && len(results.Errors) > 0 {
+			return NewObjError(NewError(ErrProviderUnavailable, "", "search: every provider failed for %s", uri.ID))
 		}
 		return
 	}
 
-	if handler, exists := handlers[splitURI[0]]; exists {
-		obj.Provider = splitURI[0]
-		if len(splitURI) > 2 {
-			id := splitURI[2]
-			switch splitURI[1] {
+	if handler, exists := GetHandler(uri.Provider); exists {
+		obj.Provider = uri.Provider
+		if err := service.LoginError(uri.Provider); err != nil {
+			return NewObjError(NewError(ErrProviderUnavailable, uri.Provider, "%s: not logged in: %v", uri.Provider, err))
+		}
+		if uri.Sub != "" {
+			return GetSubObjectLive(uri)
+		}
+		if uri.Type != "" && uri.ID != "" {
+			id := uri.ID
+			switch uri.Type {
 			case "artist", "creator", "user", "channel", "chan", "streamer":
 				creator, err := handler.Creator(id)
 				if err != nil {
 					Error.Printf("Invalid creator %s: %v\n", id, err)
-					return NewObjError(fmt.Sprintf("invalid creator %s: %v", id, err))
+					return NewObjError(WrapError(err, obj.Provider, "invalid creator %s", id))
 				}
 				obj.Type = "creator"
-				creatorJSON, err := json.Marshal(creator)
-				if err != nil {
-					Error.Printf("Unable to marshal creator: %v\n", err)
-					return NewObjError(fmt.Sprintf("invalid creator %s: %v", id, err))
-				}
-				if err := obj.Object.UnmarshalJSON(creatorJSON); err != nil {
-					Error.Printf("Unable to unmarshal creator: %v\n", err)
-					return NewObjError(fmt.Sprintf("invalid creator %s: %v", id, err))
-				}
+				obj.Object = creator
 			case "album":
 				Trace.Printf("Searching for album %s\n", mediaURI)
 				album, err := handler.Album(id)
 				if err != nil {
 					Error.Printf("Invalid album %s: %v\n", id, err)
-					return NewObjError(fmt.Sprintf("invalid album %s: %v", id, err))
+					return NewObjError(WrapError(err, obj.Provider, "invalid album %s", id))
 				}
 				Trace.Printf("Found album %s\n", mediaURI)
 				obj.Type = "album"
-				albumJSON, err := json.Marshal(album)
-				if err != nil {
-					Error.Printf("Unable to marshal album: %v\n", err)
-					return NewObjError(fmt.Sprintf("invalid album %s: %v", id, err))
-				}
-				if err := obj.Object.UnmarshalJSON(albumJSON); err != nil {
-					Error.Printf("Unable to unmarshal album: %v\n", err)
-					return NewObjError(fmt.Sprintf("invalid album %s: %v", id, err))
-				}
+				obj.Object = album
 				Trace.Printf("Successfully loaded album %s\n", mediaURI)
 			case "track", "song", "video", "audio", "stream":
 				stream, err := handler.Stream(id)
 				if err != nil {
 					Error.Printf("Invalid stream %s: %v\n", id, err)
-					return NewObjError(fmt.Sprintf("invalid stream %s: %v", id, err))
+					return NewObjError(WrapError(err, obj.Provider, "invalid stream %s", id))
 				}
 				obj.Type = "stream"
 				stream.Transcribe()
-				streamJSON, err := json.Marshal(stream)
-				if err != nil {
-					Error.Printf("Unable to marshal stream: %v\n", err)
-					return NewObjError(fmt.Sprintf("invalid stream %s: %v", id, err))
-				}
-				if err := obj.Object.UnmarshalJSON(streamJSON); err != nil {
-					Error.Printf("Unable to unmarshal stream: %v\n", err)
-					return NewObjError(fmt.Sprintf("invalid stream %s: %v", id, err))
+				for i := 0; i < len(stream.Formats); i++ {
+					stream.Formats[i].GenerateURL(obj.URI)
 				}
+				obj.Object = stream
 			}
 
+			if obj.Type == "" {
+				return NewObjError(NewError(ErrBadURI, obj.Provider, "unknown object type %s", uri.Type))
+			}
 			Info.Printf("Successfully found live object for %s\n", mediaURI)
 			return obj
 		}
+		return NewObjError(NewError(ErrBadURI, obj.Provider, "need object type and ID"))
 	}
 
 	Error.Printf("Failed to find live object for %s\n", mediaURI)
+	if err := service.Health(uri.Provider); err != nil {
+		return NewObjError(NewError(ErrProviderUnavailable, uri.Provider, "provider %s is unavailable: %v", uri.Provider, err))
+	}
+	return NewObjError(NewError(ErrBadURI, "", "no provider for %s", mediaURI))
+}
+
+// GetSubObjectLive returns a sub-resource of an object, using the cached object where possible
+func GetSubObjectLive(uri *URI) (obj *Object) {
+	base := GetObject(uri.Base().String())
+	if base == nil {
+		return NewObjError(NewError(ErrNotFound, uri.Provider, "no object for %s", uri.Base().String()))
+	}
+	if base.Type == "error" {
+		return base
+	}
+	obj = &Object{URI: uri.String(), Provider: base.Provider}
+
+	switch uri.Sub {
+	case "artwork":
+		size := 0
+		if len(uri.SubArgs) > 0 {
+			var err error
+			size, err = strconv.Atoi(uri.SubArgs[0])
+			if err != nil || size < 0 {
+				return NewObjError(NewError(ErrBadURI, obj.Provider, "invalid artwork size %s", uri.SubArgs[0]))
+			}
+		}
+		//Prefer still images unless a file type was requested
+		fileType := uri.Params.Get("type")
+		if fileType == "" {
+			fileType = "jpg"
+		}
+		artworks := objectArtworks(base)
+		artwork := BestArtwork(artworks, size, fileType)
+		if artwork == nil && uri.Params.Get("type") == "" {
+			artwork = BestArtwork(artworks, size, "")
+		}
+		if artwork == nil {
+			return NewObjError(NewError(ErrNotFound, obj.Provider, "no artwork for %s", base.URI))
+		}
+		obj.Type = "artwork"
+		obj.Object = artwork
+		return obj
+	case "transcript":
+		stream := base.Stream()
+		if stream == nil {
+			return NewObjError(NewError(ErrBadURI, obj.Provider, "%s has no transcript, not a stream", base.URI))
+		}
+		if stream.Transcript == nil {
+			stream.Transcribe()
+		}
+		if stream.Transcript == nil {
+			return NewObjError(NewError(ErrNotFound, obj.Provider, "no transcript for %s", base.URI))
+		}
+		//Time the transcript by line, word or syllable, ex: ?granularity=word
+		transcript, err := stream.Transcript.View(uri.Params.Get("granularity"))
+		if err != nil {
+			return NewObjError(err)
+		}
+		obj.Type = "transcript"
+		obj.Object = transcript
+		return obj
+	}
+	return NewObjError(NewError(ErrBadURI, obj.Provider, "unknown sub-resource %s", uri.Sub))
+}
+
+// objectArtworks returns the artworks of an object, falling back to the album artworks of a stream
+func objectArtworks(obj *Object) []*ObjectArtwork {
+	switch obj.Type {
+	case "creator":
+		if creator := obj.Creator(); creator != nil {
+			return creator.Artworks
+		}
+	case "album":
+		if album := obj.Album(); album != nil {
+			return album.Artworks
+		}
+	case "stream":
+		stream := obj.Stream()
+		if stream == nil {
+			return nil
+		}
+		if len(stream.Artworks) > 0 {
+			return stream.Artworks
+		}
+		if stream.Album != nil {
+			if album := stream.Album.Album(); album != nil && len(album.Artworks) > 0 {
+				return album.Artworks
+			}
+			if album := expandObject(stream.Album).Album(); album != nil {
+				return album.Artworks
+			}
+		}
+	}
 	return nil
 }
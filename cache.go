@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheSearchItems = 25 //The maximum number of results of each type when searching the cache
+)
+
+var (
+	//Default lifetimes of each cached object type, used when the configuration doesn't override them
+	cacheTTLs = map[string]time.Duration{
+		"search":  time.Hour * 2,
+		"creator": time.Hour * 12,
+		"album":   time.Hour * (24 * 30),
+		"stream":  time.Hour * (24 * 30),
+
+		"artwork":    time.Hour * (24 * 30),
+		"transcript": time.Hour * (24 * 7),
+		"charts":     time.Minute * 10,
+		"playlist":   time.Hour * 12,
+	}
+	//Default periods after expiry where a cached object may still be served while it refreshes
+	cacheGraces = map[string]time.Duration{
+		"search":  time.Hour * 1,
+		"creator": time.Hour * 24,
+		"album":   time.Hour * (24 * 7),
+		"stream":  time.Hour * (24 * 7),
+
+		"artwork":    time.Hour * (24 * 7),
+		"transcript": time.Hour * (24 * 7),
+		"charts":     time.Minute * 5,
+		"playlist":   time.Hour * 24,
+	}
+
+	refreshing     = make(map[string]bool) //URIs that are currently being refreshed in the background
+	refreshingLock sync.Mutex
+)
+
+// CacheConfig holds the cache lifetimes for each object type
+type CacheConfig struct {
+	TTLs   map[string]string `json:"ttl"`   //How long an object of a given type stays fresh, ex: {"search": "2h"}
+	Graces map[string]string `json:"grace"` //How long an expired object of a given type may still be served while it refreshes
+}
+
+// TTL returns how long an object of the given type stays fresh
+func (c *CacheConfig) TTL(objType string) time.Duration {
+	objType = cacheType(objType)
+	if c != nil {
+		if ttl, ok := c.duration(c.TTLs, objType); ok {
+			return ttl
+		}
+	}
+	return cacheTTLs[objType]
+}
+
+// Grace returns how long an expired object of the given type may still be served
+func (c *CacheConfig) Grace(objType string) time.Duration {
+	objType = cacheType(objType)
+	if c != nil {
+		if grace, ok := c.duration(c.Graces, objType); ok {
+			return grace
+		}
+	}
+	return cacheGraces[objType]
+}
+
+func (c *CacheConfig) duration(durations map[string]string, objType string) (time.Duration, bool) {
+	value, exists := durations[objType]
+	if !exists {
+		return 0, false
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		Warning.Printf("Invalid cache duration %s for %s, using the default: %v\n", value, objType, err)
+		return 0, false
+	}
+	return duration, true
+}
+
+// cacheType returns the cache category for the given object type
+func cacheType(objType string) string {
+	switch objType {
+	case "artist", "creator", "user", "channel", "chan", "streamer":
+		return "creator"
+	case "track", "song", "video", "audio", "stream":
+		return "stream"
+	}
+	return objType
+}
+
+// RefreshObject fetches a live copy of the given URI and replaces the cached copy with it
+func RefreshObject(uri string) (obj *Object) {
+	refreshingLock.Lock()
+	if refreshing[uri] {
+		refreshingLock.Unlock()
+		return nil
+	}
+	refreshing[uri] = true
+	refreshingLock.Unlock()
+	defer func() {
+		refreshingLock.Lock()
+		delete(refreshing, uri)
+		refreshingLock.Unlock()
+	}()
+
+	Trace.Println("Refreshing " + uri)
+	obj = GetObjectLive(uri)
+	if obj == nil || obj.Type == "error" {
+		Warning.Println("Failed to refresh " + uri + ", keeping the cached copy")
+		return obj
+	}
+	obj.Sync()
+	return obj
+}
+
+// IsRefreshing returns true if the given URI is being refreshed right now
+func IsRefreshing(uri string) bool {
+	refreshingLock.Lock()
+	defer refreshingLock.Unlock()
+	return refreshing[uri]
+}
+
+// SearchCached returns the cached creators, albums and streams whose names match every word of the query, optionally only from the given providers
+func SearchCached(query string, filter []string) (results *ObjectSearchResults) {
+	results = &ObjectSearchResults{Query: query, Provider: "libremedia"}
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return
+	}
+
+	filepath.WalkDir("cache", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			//Search results and best matches only link to objects that are cached elsewhere
+			switch entry.Name() {
+			case "search", "bestmatch":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".json" {
+			return nil
+		}
+		obj := readObjectCache(path, path)
+		if obj == nil {
+			return nil
+		}
+		if len(filter) > 0 && !inFilter(filter, obj.Provider) {
+			return nil
+		}
+
+		switch cacheType(obj.Type) {
+		case "creator":
+			if creator := obj.Creator(); creator != nil && len(results.Creators) < cacheSearchItems && matchTerms(terms, creator.Name) {
+				results.Creators = append(results.Creators, obj)
+			}
+		case "album":
+			if album := obj.Album(); album != nil && len(results.Albums) < cacheSearchItems && matchTerms(terms, album.Name) {
+				results.Albums = append(results.Albums, obj)
+			}
+		case "stream":
+			if stream := obj.Stream(); stream != nil && len(results.Streams) < cacheSearchItems {
+				names := stream.Name
+				for i := 0; i < len(stream.Creators); i++ {
+					if creator := stream.Creators[i].Creator(); creator != nil {
+						names += " " + creator.Name
+					}
+				}
+				if matchTerms(terms, names) {
+					results.Streams = append(results.Streams, obj)
+				}
+			}
+		}
+		return nil
+	})
+	results.Merge()
+	return
+}
+
+// matchTerms returns true if the text contains every term
+func matchTerms(terms []string, text string) bool {
+	text = strings.ToLower(text)
+	for i := 0; i < len(terms); i++ {
+		if !strings.Contains(text, terms[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// inFilter returns true if the provider is one of the providers in the filter
+func inFilter(filter []string, provider string) bool {
+	for i := 0; i < len(filter); i++ {
+		if filter[i] == provider {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	enriching     = make(map[string]bool) //The URIs being enriched right now
+	enrichingLock sync.Mutex
+)
+
+// Enrich finds this object on the other providers and caches a copy with any empty metadata fields filled in from them, recording which provider each field came from
+// The object itself is left untouched, as it may be served while this runs
+func (src *Object) Enrich() {
+	if src.Enriched || service.IsOffline() {
+		return
+	}
+	switch src.Type {
+	case "creator", "album", "stream":
+	default:
+		return
+	}
+
+	//Only enrich each object once at a time, as it searches every other provider
+	enrichingLock.Lock()
+	if enriching[src.URI] {
+		enrichingLock.Unlock()
+		return
+	}
+	enriching[src.URI] = true
+	enrichingLock.Unlock()
+	defer func() {
+		enrichingLock.Lock()
+		delete(enriching, src.URI)
+		enrichingLock.Unlock()
+	}()
+
+	enriched := src.enrichCopy()
+	matches := src.enrichMatches()
+	for i := 0; i < len(matches); i++ {
+		match := GetObject(matches[i])
+		if match == nil || match.Type != src.Type || match.Provider == src.Provider {
+			continue
+		}
+		Trace.Println("Enriching " + src.URI + " from " + match.URI)
+		switch src.Type {
+		case "creator":
+			enriched.enrichCreator(match)
+		case "album":
+			enriched.enrichAlbum(match)
+		case "stream":
+			enriched.enrichStream(match)
+		}
+	}
+	enriched.Enriched = true
+	enriched.Sync()
+}
+
+// enrichCopy returns a copy of this object whose payload and sources can be filled in without touching the original
+func (src *Object) enrichCopy() *Object {
+	enriched := *src
+	switch payload := src.Object.(type) {
+	case *ObjectCreator:
+		creator := *payload
+		enriched.Object = &creator
+	case *ObjectAlbum:
+		album := *payload
+		enriched.Object = &album
+	case *ObjectStream:
+		stream := *payload
+		enriched.Object = &stream
+	}
+	enriched.Sources = make(map[string]string)
+	for field, provider := range src.Sources {
+		enriched.Sources[field] = provider
+	}
+	return &enriched
+}
+
+// enrichCreator fills in the empty fields of a creator from the same creator on another provider
+func (src *Object) enrichCreator(match *Object) {
+	creator, from := src.Creator(), match.Creator()
+	if creator == nil || from == nil {
+		return
+	}
+	if creator.Description == "" && from.Description != "" {
+		creator.Description = from.Description
+		src.setSource("description", match.Provider)
+	}
+	if len(creator.Artworks) == 0 && len(from.Artworks) > 0 {
+		creator.Artworks = from.Artworks
+		src.setSource("artworks", match.Provider)
+	}
+	if creator.DateTime == nil && from.DateTime != nil {
+		creator.DateTime = from.DateTime
+		src.setSource("datetime", match.Provider)
+	}
+	if len(creator.Genres) == 0 && len(from.Genres) > 0 {
+		creator.Genres = from.Genres
+		src.setSource("genres", match.Provider)
+	}
+}
+
+// enrichAlbum fills in the empty fields of an album from the same album on another provider
+func (src *Object) enrichAlbum(match *Object) {
+	album, from := src.Album(), match.Album()
+	if album == nil || from == nil {
+		return
+	}
+	if album.Description == "" && from.Description != "" {
+		album.Description = from.Description
+		src.setSource("description", match.Provider)
+	}
+	if len(album.Artworks) == 0 && len(from.Artworks) > 0 {
+		album.Artworks = from.Artworks
+		src.setSource("artworks", match.Provider)
+	}
+	if album.DateTime == "" && from.DateTime != "" {
+		album.DateTime = from.DateTime
+		src.setSource("datetime", match.Provider)
+	}
+	if album.Label == "" && from.Label != "" {
+		album.Label = from.Label
+		src.setSource("label", match.Provider)
+	}
+	if len(album.Copyrights) == 0 && len(from.Copyrights) > 0 {
+		album.Copyrights = from.Copyrights
+		src.setSource("copyrights", match.Provider)
+	}
+}
+
+// enrichStream fills in the empty fields of a stream from the same stream on another provider
+func (src *Object) enrichStream(match *Object) {
+	stream, from := src.Stream(), match.Stream()
+	if stream == nil || from == nil {
+		return
+	}
+	if len(stream.Artworks) == 0 && len(from.Artworks) > 0 {
+		stream.Artworks = from.Artworks
+		src.setSource("artworks", match.Provider)
+	}
+	if stream.DateTime == "" && from.DateTime != "" {
+		stream.DateTime = from.DateTime
+		src.setSource("datetime", match.Provider)
+	}
+}
+
+// setSource records the provider a field was filled in from
+func (src *Object) setSource(field, provider string) {
+	if src.Sources == nil {
+		src.Sources = make(map[string]string)
+	}
+	src.Sources[field] = provider
+}
+
+// enrichMatches returns the URIs of this object on other providers, from its known alternatives, its ISRC or UPC, or otherwise a search by name
+func (src *Object) enrichMatches() []string {
+	others := make([]string, 0)
+	for i := 0; i < len(providers); i++ {
+		if providers[i] != src.Provider && service.IsHealthy(providers[i]) {
+			others = append(others, providers[i])
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+
+	matches := make([]string, 0)
+	seen := make(map[string]bool)
+	found := make(map[string]bool) //The providers that already have a match
+	add := func(uri string) {
+		parsed, err := ParseURI(uri)
+		if err != nil || seen[uri] || found[parsed.Provider] || parsed.Provider == src.Provider {
+			return
+		}
+		seen[uri] = true
+		found[parsed.Provider] = true
+		matches = append(matches, uri)
+	}
+	for i := 0; i < len(src.Alternatives); i++ {
+		add(src.Alternatives[i])
+	}
+
+	//Look up standard identifiers on the providers that support them
+	namespace, code := "", ""
+	switch src.Type {
+	case "stream":
+		if stream := src.Stream(); stream != nil && stream.ISRC != "" {
+			namespace, code = "isrc", strings.ToUpper(stream.ISRC)
+		}
+	case "album":
+		if album := src.Album(); album != nil && album.UPC != "" {
+			namespace, code = "upc", normalizeUPC(album.UPC)
+		}
+	}
+	if code != "" {
+		for i := 0; i < len(others); i++ {
+			handler, _ := GetHandler(others[i])
+			lookup, ok := handler.(IdentifierHandler)
+			if !ok || found[others[i]] {
+				continue
+			}
+			if uri, err := lookupIdentifier(lookup, namespace, code); err == nil {
+				add(uri)
+			}
+		}
+	}
+
+	//Search the remaining providers by name
+	remaining := make([]string, 0)
+	for i := 0; i < len(others); i++ {
+		if !found[others[i]] {
+			remaining = append(remaining, others[i])
+		}
+	}
+	query := src.enrichQuery()
+	if len(remaining) == 0 || query == "" {
+		return matches
+	}
+	results := service.Search(query, remaining)
+	candidates, same := results.Streams, sameStream
+	switch src.Type {
+	case "creator":
+		candidates, same = results.Creators, sameCreator
+	case "album":
+		candidates, same = results.Albums, sameAlbum
+	}
+	for i := 0; i < len(candidates); i++ {
+		if !same(src, candidates[i]) {
+			continue
+		}
+		add(candidates[i].URI)
+		for j := 0; j < len(candidates[i].Alternatives); j++ {
+			add(candidates[i].Alternatives[j])
+		}
+	}
+	return matches
+}
+
+// enrichQuery returns the search query used to find this object on other providers, its name and lead creator
+func (src *Object) enrichQuery() string {
+	name, creators := "", []*Object(nil)
+	switch src.Type {
+	case "creator":
+		if creator := src.Creator(); creator != nil {
+			return creator.Name
+		}
+	case "album":
+		if album := src.Album(); album != nil {
+			name, creators = album.Name, album.Creators
+		}
+	case "stream":
+		if stream := src.Stream(); stream != nil {
+			name, creators = stream.Name, stream.Creators
+		}
+	}
+	if name == "" {
+		return ""
+	}
+	if len(creators) > 0 {
+		if creator := creators[0].Creator(); creator != nil && creator.Name != "" {
+			return creator.Name + " " + name
+		}
+	}
+	return name
+}
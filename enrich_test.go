@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnrichCopyLeavesOriginal(t *testing.T) {
+	src := &Object{URI: "tidal:album:1", Type: "album", Provider: "tidal", Sources: map[string]string{"label": "spotify"}, Object: &ObjectAlbum{Name: "Discovery", Label: "Virgin"}}
+	match := &Object{URI: "spotify:album:1", Type: "album", Provider: "spotify", Object: &ObjectAlbum{Name: "Discovery", Description: "The second album", DateTime: "2001-03-12"}}
+
+	enriched := src.enrichCopy()
+	enriched.enrichAlbum(match)
+
+	if album := src.Album(); album.Description != "" || album.DateTime != "" {
+		t.Errorf("original album was filled in: %+v", album)
+	}
+	if !reflect.DeepEqual(src.Sources, map[string]string{"label": "spotify"}) {
+		t.Errorf("original sources = %v, want them left alone", src.Sources)
+	}
+	if album := enriched.Album(); album.Description != "The second album" || album.DateTime != "2001-03-12" || album.Label != "Virgin" {
+		t.Errorf("enriched album = %+v, want its description and date filled in", album)
+	}
+	want := map[string]string{"label": "spotify", "description": "spotify", "datetime": "spotify"}
+	if !reflect.DeepEqual(enriched.Sources, want) {
+		t.Errorf("enriched sources = %v, want %v", enriched.Sources, want)
+	}
+}
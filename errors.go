@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Error codes that describe why an object couldn't be served
+const (
+	ErrNotFound            = "not_found"            //The object doesn't exist upstream
+	ErrProviderUnavailable = "provider_unavailable" //The provider couldn't be reached or failed to respond
+	ErrUnauthorized        = "unauthorized"         //The provider or libremedia rejected the credentials in use
+	ErrRegionRestricted    = "region_restricted"    //The object exists but isn't available in this region
+	ErrRateLimited         = "rate_limited"         //The provider is throttling requests
+	ErrBadURI              = "bad_uri"              //The URI couldn't be understood
+	ErrNotAcceptable       = "not_acceptable"       //No format satisfies the requested quality within the user's limits
+	ErrInternal            = "internal"             //Something went wrong within libremedia itself
+)
+
+// ObjectError holds a structured error describing why an object couldn't be served
+type ObjectError struct {
+	Code      string `json:"code,omitempty"`      //One of the Err* codes
+	Provider  string `json:"provider,omitempty"`  //The provider that caused this error, if any
+	Message   string `json:"error,omitempty"`     //A human readable description of this error
+	Retryable bool   `json:"retryable,omitempty"` //Whether or not the same request may succeed later
+}
+
+// NewError returns a structured error, retryable if the code suggests a later attempt may succeed
+func NewError(code, provider, format string, args ...interface{}) *ObjectError {
+	return &ObjectError{
+		Code:      code,
+		Provider:  provider,
+		Message:   fmt.Sprintf(format, args...),
+		Retryable: code == ErrProviderUnavailable || code == ErrRateLimited,
+	}
+}
+
+// WrapError returns a structured error that describes err, keeping its code if it already has one
+func WrapError(err error, provider, format string, args ...interface{}) *ObjectError {
+	msg := fmt.Sprintf(format, args...)
+	var objErr *ObjectError
+	if errors.As(err, &objErr) {
+		if objErr.Provider != "" {
+			provider = objErr.Provider
+		}
+		return &ObjectError{
+			Code:      objErr.Code,
+			Provider:  provider,
+			Message:   msg + ": " + objErr.Message,
+			Retryable: objErr.Retryable,
+		}
+	}
+	return NewError(ErrInternal, provider, "%s: %v", msg, err)
+}
+
+// Error returns the message of this error
+func (e *ObjectError) Error() string {
+	return e.Message
+}
+
+// Status returns the HTTP status code that matches this error
+func (e *ObjectError) Status() int {
+	switch e.Code {
+	case ErrNotFound:
+		return 404
+	case ErrProviderUnavailable:
+		return 503
+	case ErrUnauthorized:
+		return 401
+	case ErrRegionRestricted:
+		return 451
+	case ErrRateLimited:
+		return 429
+	case ErrBadURI:
+		return 400
+	case ErrNotAcceptable:
+		return 406
+	}
+	return 500
+}
+
+// JSON returns this error as serialized JSON
+func (e *ObjectError) JSON() []byte {
+	objJSON, err := json.Marshal(e)
+	if err != nil {
+		return nil
+	}
+	return objJSON
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	failoverPath = "failover.json" //Where streams that failed and their substitutes are stored
+	failoverTTL  = time.Hour * 24  //How long a substitute is used before the original stream is tried again
+)
+
+var (
+	failovers     = make(map[string]*Failover) //Substitutes by the URI of the stream that failed
+	failoversLock sync.Mutex
+)
+
+// Failover holds the substitute for a stream that couldn't be served
+type Failover struct {
+	URI     string    `json:"uri"`     //The URI of the equivalent stream that was served instead
+	Reason  string    `json:"reason"`  //Why the original stream couldn't be served
+	Expires time.Time `json:"expires"` //When to try the original stream again
+}
+
+// streamServer serves a format of a stream, like Service.Stream or Service.Download
+type streamServer func(w http.ResponseWriter, r *http.Request, stream *ObjectStream, format int) error
+
+// LoadFailovers reads the stored substitutes into memory, starting from nothing if there aren't any
+func LoadFailovers() error {
+	failoverData, err := ioutil.ReadFile(failoverPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	failoversLock.Lock()
+	defer failoversLock.Unlock()
+	return json.Unmarshal(failoverData, &failovers)
+}
+
+// GetFailover returns the substitute for a stream that failed recently, or nil if there isn't one
+func GetFailover(uri string) *Failover {
+	failoversLock.Lock()
+	defer failoversLock.Unlock()
+	failover, exists := failovers[uri]
+	if !exists {
+		return nil
+	}
+	if time.Now().After(failover.Expires) {
+		delete(failovers, uri)
+		saveFailovers()
+		return nil
+	}
+	return failover
+}
+
+// SetFailover remembers the substitute for a stream that failed, or forgets it if the substitute is nil
+func SetFailover(uri string, failover *Failover) {
+	failoversLock.Lock()
+	defer failoversLock.Unlock()
+	if failover == nil {
+		delete(failovers, uri)
+	} else {
+		failovers[uri] = failover
+	}
+	saveFailovers()
+}
+
+// saveFailovers writes the substitutes to disk, the caller must hold the lock
+func saveFailovers() {
+	failoverData, err := json.Marshal(failovers)
+	if err == nil {
+		err = writeFileAtomic(failoverPath, failoverData, 0644)
+	}
+	if err != nil {
+		Error.Printf("Failed to save failovers: %v\n", err)
+	}
+}
+
+// ServeStream serves a stream in the best format a user may have, or an equivalent stream from another provider if its provider can't serve it, reporting the substitute with the X-Libremedia-Failover header
+// It returns the stream that was served, or the one that was being served when the response failed, so it can be counted
+func (s *Service) ServeStream(w http.ResponseWriter, r *http.Request, obj *Object, user *ServiceUser, serve streamServer) (*Object, error) {
+	tracked := &failoverWriter{ResponseWriter: w}
+
+	//A request no format of the original can satisfy is the client's fault, so no other provider is tried
+	query := r.URL.Query()
+	formats, err := s.NegotiateFormats(obj.Stream(), user, query)
+	if err != nil {
+		return obj, err
+	}
+	failoverQuery := failoverQuery(query, formats[0])
+
+	//Go straight to the substitute of a stream that failed recently
+	if failover := GetFailover(obj.URI); failover != nil {
+		match := GetObject(failover.URI)
+		if match == nil || match.Stream() == nil {
+			SetFailover(obj.URI, nil)
+		} else {
+			err := s.serveFailover(tracked, r, obj, match, user, failoverQuery, serve)
+			if err == nil || tracked.wroteHeader {
+				return match, err
+			}
+			if providerFailed(err, match.Provider) {
+				SetFailover(obj.URI, nil)
+			}
+		}
+	}
+
+	if err = serveFormats(tracked, r, obj.Stream(), formats, serve); err != nil {
+		err = WrapError(err, obj.Provider, "libremedia: all formats available to select from matched stream object failed")
+	}
+	if err == nil || tracked.wroteHeader || !canFailover(err) {
+		return obj, err
+	}
+
+	Warning.Printf("Failed to stream %s, looking for a substitute: %v\n", obj.URI, err)
+	matches := obj.enrichMatches()
+	for i := 0; i < len(matches); i++ {
+		match := GetObject(matches[i])
+		if match == nil || match.Type != "stream" || match.Stream() == nil {
+			continue
+		}
+		failErr := s.serveFailover(tracked, r, obj, match, user, failoverQuery, serve)
+		if failErr == nil {
+			Info.Println("Substituted " + obj.URI + " with " + match.URI)
+			//Only remember substitutes for the provider's own failures, as they're shared with every user
+			if providerFailed(err, obj.Provider) {
+				SetFailover(obj.URI, &Failover{URI: match.URI, Reason: err.Error(), Expires: time.Now().Add(failoverTTL)})
+			}
+			return match, nil
+		}
+		if tracked.wroteHeader {
+			return match, failErr
+		}
+	}
+	return obj, err
+}
+
+// GetStreamLive returns a stream object for streaming, falling back to its cached copy if it can no longer be fetched, so it can still be matched on another provider
+func GetStreamLive(uri string) *Object {
+	obj := GetObjectLive(uri)
+	if obj == nil || obj.Err() == nil || !canFailover(obj.Err()) {
+		return obj
+	}
+	if cached := GetObjectOffline(uri); cached != nil && cached.Stream() != nil {
+		Trace.Println("Using the cached copy of " + uri + " to look for a substitute: " + obj.Err().Error())
+		return cached
+	}
+	return obj
+}
+
+// serveFailover serves an equivalent stream in place of one that failed, with the constraints of the original request
+func (s *Service) serveFailover(w *failoverWriter, r *http.Request, obj, match *Object, user *ServiceUser, query url.Values, serve streamServer) error {
+	Trace.Println("Trying " + match.URI + " in place of " + obj.URI)
+	w.Header().Set("X-Libremedia-Failover", match.URI)
+	err := s.serveNegotiated(w, r, match.Stream(), user, query, serve)
+	if err != nil && !w.wroteHeader {
+		w.Header().Del("X-Libremedia-Failover")
+	}
+	return err
+}
+
+// failoverQuery returns the constraints of a request to serve a substitute with
+// Format IDs differ between providers, so an explicitly requested format is swapped for the quality of that format
+func failoverQuery(original url.Values, best *ObjectFormat) url.Values {
+	query := url.Values{}
+	for key, values := range original {
+		query[key] = values
+	}
+	if query.Get("format") == "" {
+		return query
+	}
+	query.Del("format")
+	if best.BitRate > 0 {
+		query.Set("maxBitrate", strconv.Itoa(int(best.BitRate)))
+	}
+	if best.SampleRate > 0 {
+		query.Set("maxSampleRate", strconv.Itoa(int(best.SampleRate)))
+	}
+	query.Set("lossless", strconv.FormatBool(losslessCodecs[strings.ToLower(best.Codec)]))
+	query.Del("codec")
+	return query
+}
+
+// serveNegotiated serves the best format of a stream that satisfies a request, falling back to worse formats if it fails
+func (s *Service) serveNegotiated(w http.ResponseWriter, r *http.Request, stream *ObjectStream, user *ServiceUser, query url.Values, serve streamServer) error {
+	formats, err := s.NegotiateFormats(stream, user, query)
+	if err != nil {
+		return err
+	}
+	if err := serveFormats(w, r, stream, formats, serve); err != nil {
+		return WrapError(err, stream.Provider, "libremedia: all formats available to select from matched stream object failed")
+	}
+	return nil
+}
+
+// canFailover returns true if another provider may be able to serve a stream that failed with an error
+func canFailover(err error) bool {
+	var objErr *ObjectError
+	if errors.As(err, &objErr) {
+		return objErr.Code != ErrBadURI && objErr.Code != ErrNotAcceptable
+	}
+	return true
+}
+
+// providerFailed returns true if a stream failed because its provider couldn't serve it to anyone, rather than because of one request or a passing hiccup
+func providerFailed(err error, provider string) bool {
+	var objErr *ObjectError
+	if !errors.As(err, &objErr) || objErr.Provider != provider {
+		return false
+	}
+	switch objErr.Code {
+	case ErrNotFound, ErrProviderUnavailable, ErrUnauthorized, ErrRegionRestricted:
+		return true
+	}
+	return false
+}
+
+// failoverWriter tracks whether a response was started, after which it's too late to serve another stream
+type failoverWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *failoverWriter) WriteHeader(statusCode int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *failoverWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFailoverErrors(t *testing.T) {
+	tests := []struct {
+		err            error
+		canFailover    bool
+		providerFailed bool
+	}{
+		{err: NewError(ErrNotFound, "tidal", "removed"), canFailover: true, providerFailed: true},
+		{err: NewError(ErrRegionRestricted, "tidal", "region locked"), canFailover: true, providerFailed: true},
+		{err: NewError(ErrProviderUnavailable, "tidal", "down"), canFailover: true, providerFailed: true},
+		{err: WrapError(NewError(ErrUnauthorized, "tidal", "logged out"), "", "libremedia"), canFailover: true, providerFailed: true},
+		{err: NewError(ErrRateLimited, "tidal", "slow down"), canFailover: true},
+		{err: NewError(ErrNotFound, "spotify", "another provider"), canFailover: true},
+		{err: NewError(ErrInternal, "tidal", "libremedia broke"), canFailover: true},
+		{err: errors.New("no handler"), canFailover: true},
+		{err: NewError(ErrNotAcceptable, "tidal", "too good")},
+		{err: NewError(ErrBadURI, "tidal", "bad uri")},
+	}
+	for _, test := range tests {
+		if got := canFailover(test.err); got != test.canFailover {
+			t.Errorf("canFailover(%v) = %v, want %v", test.err, got, test.canFailover)
+		}
+		if got := providerFailed(test.err, "tidal"); got != test.providerFailed {
+			t.Errorf("providerFailed(%v) = %v, want %v", test.err, got, test.providerFailed)
+		}
+	}
+}
+
+func TestFailoverQuery(t *testing.T) {
+	lossless := &ObjectFormat{ID: 1, Name: "LOSSLESS", Codec: "flac", BitRate: 1411000, SampleRate: 44100}
+	high := &ObjectFormat{ID: 2, Name: "HIGH", Codec: "aac", BitRate: 320000, SampleRate: 44100}
+	tests := []struct {
+		query string
+		best  *ObjectFormat
+		want  url.Values
+	}{
+		{query: "", best: lossless, want: url.Values{}},
+		{query: "codec=flac&maxBitrate=2000000", best: lossless, want: url.Values{"codec": {"flac"}, "maxBitrate": {"2000000"}}},
+		{query: "format=1", best: lossless, want: url.Values{"maxBitrate": {"1411000"}, "maxSampleRate": {"44100"}, "lossless": {"true"}}},
+		{query: "format=2&codec=aac", best: high, want: url.Values{"maxBitrate": {"320000"}, "maxSampleRate": {"44100"}, "lossless": {"false"}}},
+		{query: "format=0", best: &ObjectFormat{Codec: "vorbis"}, want: url.Values{"lossless": {"false"}}},
+	}
+	for _, test := range tests {
+		original, _ := url.ParseQuery(test.query)
+		if got := failoverQuery(original, test.best); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("failoverQuery(%q) = %v, want %v", test.query, got, test.want)
+		}
+		if again, _ := url.ParseQuery(test.query); !reflect.DeepEqual(original, again) {
+			t.Errorf("failoverQuery(%q) changed the original request to %v", test.query, original)
+		}
+	}
+}
+
+func TestServeStreamNegotiationDoesntFailover(t *testing.T) {
+	obj := &Object{URI: "tidal:track:1", Type: "stream", Provider: "tidal", Object: &ObjectStream{
+		URI:      "tidal:track:1",
+		Provider: "tidal",
+		Formats:  []*ObjectFormat{{ID: 1, Name: "LOSSLESS", Codec: "flac", BitRate: 1411000, SampleRate: 44100}},
+	}}
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{query: "format=9", code: ErrNotFound},
+		{query: "format=best", code: ErrBadURI},
+		{query: "codec=aac", code: ErrNotAcceptable},
+	}
+	for _, test := range tests {
+		served := false
+		serve := func(w http.ResponseWriter, r *http.Request, stream *ObjectStream, format int) error {
+			served = true
+			return nil
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/v1/stream/tidal:track:1?"+test.query, nil)
+		got, err := service.ServeStream(w, r, obj, nil, serve)
+		objErr, ok := err.(*ObjectError)
+		if !ok || objErr.Code != test.code {
+			t.Errorf("?%s: returned %v, want error %s", test.query, err, test.code)
+		}
+		if got != obj || served || w.Header().Get("X-Libremedia-Failover") != "" {
+			t.Errorf("?%s: served %s instead of rejecting the request", test.query, got.URI)
+		}
+		if GetFailover(obj.URI) != nil {
+			t.Errorf("?%s: recorded a failover", test.query)
+		}
+	}
+}
@@ -6,8 +6,10 @@ require (
 	github.com/JoshuaDoes/json v0.0.0-20200726213358-ec3860544ac0
 	github.com/dsoprea/go-utility v0.0.0-20221003172846-a3e1774ef349
 	github.com/eolso/librespot-golang v0.0.0-20230506023304-cdb078f4ea7f
+	github.com/golang/protobuf v1.5.3
 	github.com/librespot-org/librespot-golang v0.0.0-20220325184705-31669e5a889f
 	github.com/rhnvrm/lyric-api-go v0.1.4
+	golang.org/x/net v0.11.0
 	golang.org/x/oauth2 v0.9.0
 )
 
@@ -19,7 +21,6 @@ require (
 	github.com/dsoprea/go-logging v0.0.0-20200710184922-b02d349568dd // indirect
 	github.com/eolso/threadsafe v0.0.0-20230304165831-d28da4e4d0d3 // indirect
 	github.com/go-errors/errors v1.4.2 // indirect
-	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/gosimple/slug v1.13.1 // indirect
 	github.com/gosimple/unidecode v1.0.1 // indirect
 	github.com/jfbus/httprs v1.0.1 // indirect
@@ -34,7 +35,6 @@ require (
 	golang.org/x/crypto v0.10.0 // indirect
 	golang.org/x/exp v0.0.0-20230522175609-2e198f4a06a1 // indirect
 	golang.org/x/mod v0.11.0 // indirect
-	golang.org/x/net v0.11.0 // indirect
 	golang.org/x/sync v0.3.0 // indirect
 	golang.org/x/sys v0.9.0 // indirect
 	golang.org/x/tools v0.10.0 // indirect
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	healthInterval = time.Minute * 5  //How often to check the health of each provider by default
+	healthTimeout  = time.Second * 30 //How long to wait for a provider to respond to a health check
+)
+
+var (
+	health     = make(map[string]error) //The last health check result of each provider, nil if healthy
+	healthLock sync.RWMutex
+)
+
+// SetHealth records the health of a provider, logging any changes
+func (s *Service) SetHealth(provider string, err error) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	lastErr := health[provider]
+	if err != nil && lastErr == nil {
+		Warning.Printf("Provider %s is unhealthy: %v\n", provider, err)
+	} else if err == nil && lastErr != nil {
+		Info.Printf("Provider %s is healthy again\n", provider)
+	}
+	health[provider] = err
+}
+
+// Health returns the last health check result of a provider, nil if healthy or not a provider
+func (s *Service) Health(provider string) error {
+	healthLock.RLock()
+	defer healthLock.RUnlock()
+	return health[provider]
+}
+
+// IsHealthy returns true if the given provider passed its last health check
+func (s *Service) IsHealthy(provider string) bool {
+	return s.Health(provider) == nil
+}
+
+// IsOffline returns true if libremedia should serve only from the cache, either by configuration or because no provider is healthy
+func (s *Service) IsOffline() bool {
+	if s.Offline {
+		return true
+	}
+	healthLock.RLock()
+	defer healthLock.RUnlock()
+	if len(health) == 0 {
+		return false
+	}
+	for _, err := range health {
+		if err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHealth checks the health of every authenticated provider, retrying the login of any that failed to authenticate
+func (s *Service) CheckHealth() {
+	for i := 0; i < len(providers); i++ {
+		provider := providers[i]
+		if s.LoginError(provider) != nil {
+			//Logins may wait on the user to link an account, so don't hold up the other providers
+			go s.retryLogin(provider)
+			continue
+		}
+		handler, exists := GetHandler(provider)
+		if !exists {
+			continue
+		}
+		result := make(chan error, 1)
+		go func() {
+			result <- handler.Health()
+		}()
+		select {
+		case err := <-result:
+			s.SetHealth(provider, err)
+		case <-time.After(healthTimeout):
+			s.SetHealth(provider, fmt.Errorf("%s: health check timed out after %v", provider, healthTimeout))
+		}
+	}
+}
+
+// MonitorHealth checks the health of every provider at the configured interval
+func (s *Service) MonitorHealth() {
+	interval := healthInterval
+	if s.HealthInterval != "" {
+		duration, err := time.ParseDuration(s.HealthInterval)
+		if err != nil {
+			Warning.Printf("Invalid health interval %s, using the default: %v\n", s.HealthInterval, err)
+		} else {
+			interval = duration
+		}
+	}
+	for {
+		time.Sleep(interval)
+		s.CheckHealth()
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+)
+
+// GetIdentifierLive resolves an isrc: or upc: URI through every healthy provider that supports identifier lookup, returning the first provider's object with the others listed as its alternatives
+func GetIdentifierLive(uri *URI) (obj *Object) {
+	code := strings.ToUpper(strings.TrimSpace(uri.ID))
+	objType := "stream"
+	if uri.Provider == "upc" {
+		code = normalizeUPC(code)
+		objType = "album"
+	}
+	if code == "" {
+		return NewObjError(NewError(ErrBadURI, "", "%s: need code", uri.Provider))
+	}
+
+	found := make([]string, 0)
+	for i := 0; i < len(providers); i++ {
+		provider := providers[i]
+		handler, _ := GetHandler(provider)
+		lookup, ok := handler.(IdentifierHandler)
+		if !ok || !service.IsHealthy(provider) {
+			continue
+		}
+		providerURI, err := lookupIdentifier(lookup, uri.Provider, code)
+		if err != nil {
+			Trace.Printf("No %s %s on %s: %v\n", uri.Provider, code, provider, err)
+			continue
+		}
+		found = append(found, providerURI)
+	}
+
+	for i := 0; i < len(found); i++ {
+		match := GetObject(found[i])
+		if match == nil || match.Type != objType {
+			continue
+		}
+		alternatives := make([]string, 0, len(found)-1)
+		alternatives = append(alternatives, found[:i]...)
+		alternatives = append(alternatives, found[i+1:]...)
+		return &Object{
+			URI:          uri.String(),
+			Type:         objType,
+			Provider:     match.Provider,
+			Object:       match.Object,
+			Alternatives: alternatives,
+		}
+	}
+	return NewObjError(NewError(ErrNotFound, "", "%s: no provider found %s", uri.Provider, code))
+}
+
+// lookupIdentifier returns the URI of the object with the given isrc or upc code on a provider
+func lookupIdentifier(lookup IdentifierHandler, namespace, code string) (string, error) {
+	if namespace == "upc" {
+		return lookup.LookupUPC(code)
+	}
+	return lookup.LookupISRC(code)
+}
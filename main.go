@@ -17,11 +17,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/eolso/librespot-golang/librespot/utils"
+	"golang.org/x/net/websocket"
 )
 
 type exporterr struct {
@@ -34,6 +37,8 @@ type valid struct {
 
 var (
 	service *Service
+
+	artworkClient = &http.Client{Timeout: time.Second * 30} //Fetches artwork to proxy, giving up on slow upstreams
 )
 
 var (
@@ -94,11 +99,35 @@ func main() {
 	if err != nil {
 		Error.Println("error logging in: " + fmt.Sprintf("%v", err))
 	}
+	go service.MonitorHealth()
+	if err = service.LoadTranscribers(); err != nil {
+		Error.Println("error loading transcribers: " + fmt.Sprintf("%v", err))
+	}
+
+	if err = LoadStats(); err != nil {
+		Error.Println("error loading stats, counting without saving until " + statsPath + " is fixed: " + fmt.Sprintf("%v", err))
+	}
+	go stats.MonitorStats()
+	if err = LoadFailovers(); err != nil {
+		Error.Println("error loading failovers: " + fmt.Sprintf("%v", err))
+	}
 
 	//libremedia API v1
 	http.HandleFunc("/v1/", v1Handler)
+	http.HandleFunc("/v1/stream", v1StreamHandler)
 	http.HandleFunc("/v1/stream/", v1StreamHandler)
 	http.HandleFunc("/v1/download/", v1DownloadHandler)
+	http.HandleFunc("/v1/admin/refresh/", v1AdminRefreshHandler)
+	http.HandleFunc("/v1/providers", v1ProvidersHandler)
+	http.HandleFunc("/v1/providers/", v1ProviderIconHandler)
+	http.HandleFunc("/v1/bestmatch/", v1BestMatchHandler)
+	http.HandleFunc("/v1/transcript/", v1TranscriptHandler)
+	http.HandleFunc("/v1/queue", v1QueueHandler)
+	http.HandleFunc("/v1/queue/", v1QueueHandler)
+	http.HandleFunc("/v1/player", v1PlayerHandler)
+	http.HandleFunc("/v1/player/", v1PlayerHandler)
+	http.HandleFunc("/v1/sessions", v1SessionsHandler)
+	http.HandleFunc("/v1/sessions/", v1SessionsHandler)
 
 	//Built-in utilities that may not be recreatable in some circumstances
 	http.HandleFunc("/util/gid2id/", gid2id)
@@ -106,93 +135,475 @@ func main() {
 	//Web interfaces
 	http.HandleFunc("/", webHandler)
 
+	//Save any counts made since the last save before exiting
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+		stats.Save()
+		os.Exit(0)
+	}()
+
 	Warning.Fatal(http.ListenAndServe(service.HostAddr, nil))
 }
 
 func v1Handler(w http.ResponseWriter, r *http.Request) {
-	uri := r.URL.Path[4:]
-	obj := GetObject(uri)
+	uri, err := ParseURI(requestURI(r, "/v1/"))
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+	uri.SetParams(r.URL.Query())
+	obj := GetObject(uri.String())
 	if obj == nil {
 		jsonWriteErrorf(w, 404, "no matching object")
 		return
 	}
-	if !obj.Expanded && !obj.Expanding {
-		switch obj.Type {
-		case "album", "creator", "stream":
-			obj.Expand()
-		default:
-			go obj.Expand()
+	if objErr := obj.Err(); objErr != nil {
+		jsonWriteStatus(w, objErr.Status(), obj)
+		return
+	}
+	if service.IsOffline() {
+		w.Header().Set("X-Libremedia-Offline", "true")
+	}
+	switch obj.Type {
+	case "artwork":
+		if artwork := obj.Artwork(); artwork != nil {
+			switch r.URL.Query().Get("serve") {
+			case "redirect":
+				http.Redirect(w, r, artwork.URL, http.StatusFound)
+				return
+			case "proxy":
+				proxyArtwork(w, r, artwork)
+				return
+			}
+		}
+	case "transcript":
+	default:
+		if !service.IsOffline() && !obj.Expanded && !obj.Expanding {
+			switch obj.Type {
+			case "album", "creator", "stream":
+				obj.Expand()
+			default:
+				go obj.Expand()
+			}
+		}
+	}
+	//Authenticated users get stream URLs that work without their access key, such as in an audio element
+	if service.RequiresAuth() {
+		if user, err := service.Auth(getAccessKey(r)); err == nil {
+			obj = service.SignObject(obj, user)
 		}
 	}
 	jsonWrite(w, obj)
 }
 
-func v1DownloadHandler(w http.ResponseWriter, r *http.Request) {
-	settings := r.URL.Query()
-
-	path := strings.Split(r.URL.Path[13:], "?")
-	objectStream := GetObjectLive(path[0])
-	if objectStream == nil {
-		jsonWriteErrorf(w, 404, "no matching stream object")
+// proxyArtwork streams the image of an artwork through libremedia, giving up if the client goes away
+func proxyArtwork(w http.ResponseWriter, r *http.Request, artwork *ObjectArtwork) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, artwork.URL, nil)
+	if err != nil {
+		jsonWriteError(w, NewError(ErrInternal, artwork.Provider, "artwork: %v", err))
 		return
 	}
-	if objectStream.Type != "stream" {
-		jsonWriteErrorf(w, 404, "no matching stream object")
+	resp, err := artworkClient.Do(req)
+	if err != nil {
+		jsonWriteError(w, NewError(ErrProviderUnavailable, artwork.Provider, "artwork: %v", err))
 		return
 	}
-	stream := objectStream.Stream()
-	if stream == nil {
-		jsonWriteErrorf(w, 500, "unable to process stream object")
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		jsonWriteError(w, NewError(ErrProviderUnavailable, artwork.Provider, "artwork: %s", resp.Status))
 		return
 	}
-	formatCfg := settings.Get("format")
-	if formatCfg == "" {
-		formatCfg = "0"
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
 	}
-	formatNum, err := strconv.Atoi(formatCfg)
+	if contentLength := resp.Header.Get("Content-Length"); contentLength != "" {
+		w.Header().Set("Content-Length", contentLength)
+	}
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(service.Cache.TTL("artwork").Seconds())))
+	io.Copy(w, resp.Body)
+}
+
+func v1ProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	jsonWrite(w, service.Providers())
+}
+
+// v1QueueHandler lists or changes the queue of the requesting user, ex: POST /v1/queue/append?uri=tidal:track:12345
+func v1QueueHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := service.Auth(getAccessKey(r))
 	if err != nil {
-		jsonWriteErrorf(w, 500, "libremedia: format selection unavailable")
+		jsonWriteError(w, err)
 		return
 	}
-	err = service.Download(w, r, stream, formatNum)
-	if err != nil {
-		if settings.Get("format") != "" {
-			jsonWriteErrorf(w, 500, "libremedia: format selection unavailable for matched stream object")
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/queue"), "/")
+	if action != "" && r.Method != http.MethodPost {
+		jsonWriteErrorf(w, 405, "queue: %s needs a POST request", action)
+		return
+	}
+	query := r.URL.Query()
+
+	var change func(queue *ObjectQueue) error
+	switch action {
+	case "":
+	case "append", "insert":
+		uris := query["uri"]
+		if len(uris) == 0 {
+			jsonWriteError(w, NewError(ErrBadURI, "", "queue: need uri to %s", action))
 			return
 		}
-		if len(stream.Formats) == 0 {
-			jsonWriteErrorf(w, 404, "libremedia: no formats available to select from for matched stream object")
+		streams := make([]*Object, 0)
+		for i := 0; i < len(uris); i++ {
+			found, err := QueueStreams(uris[i])
+			if err != nil {
+				jsonWriteError(w, err)
+				return
+			}
+			streams = append(streams, found...)
+		}
+		change = func(queue *ObjectQueue) error {
+			if action == "append" {
+				queue.Append(streams...)
+				return nil
+			}
+			index, err := strconv.Atoi(query.Get("index"))
+			if err != nil {
+				return NewError(ErrBadURI, "", "queue: need index to insert at")
+			}
+			return queue.Insert(index, streams...)
+		}
+	case "move":
+		from, errFrom := strconv.Atoi(query.Get("from"))
+		to, errTo := strconv.Atoi(query.Get("to"))
+		if errFrom != nil || errTo != nil {
+			jsonWriteError(w, NewError(ErrBadURI, "", "queue: need from and to indexes to move"))
 			return
 		}
-		streamed := false
-		for i := formatNum; i < len(stream.Formats); i++ {
-			if stream.Formats[i] != nil {
-				Trace.Println("Selecting format " + stream.Formats[i].Name + " automatically")
-				err = service.Download(w, r, stream, i)
-				if err != nil {
-					continue
-				}
-				streamed = true
-				break
+		change = func(queue *ObjectQueue) error {
+			return queue.Move(from, to)
+		}
+	case "remove":
+		index, err := strconv.Atoi(query.Get("index"))
+		if err != nil {
+			jsonWriteError(w, NewError(ErrBadURI, "", "queue: need index to remove"))
+			return
+		}
+		change = func(queue *ObjectQueue) error {
+			return queue.Remove(index)
+		}
+	case "clear":
+		change = func(queue *ObjectQueue) error {
+			queue.Clear()
+			return nil
+		}
+	case "shuffle":
+		shuffle, err := strconv.ParseBool(query.Get("enabled"))
+		if err != nil {
+			jsonWriteError(w, NewError(ErrBadURI, "", "queue: need enabled=true or enabled=false to shuffle"))
+			return
+		}
+		change = func(queue *ObjectQueue) error {
+			queue.SetShuffle(shuffle)
+			return nil
+		}
+	case "save":
+		var playlist *Object
+		_, err := Queue(user, func(queue *ObjectQueue) (err error) {
+			playlist, err = SavePlaylist(query.Get("name"), queue.View())
+			return
+		})
+		if err != nil {
+			jsonWriteError(w, err)
+			return
+		}
+		if service.RequiresAuth() {
+			playlist = service.SignObject(playlist, user)
+		}
+		jsonWrite(w, playlist)
+		return
+	default:
+		jsonWriteError(w, NewError(ErrBadURI, "", "queue: unknown action %s", action))
+		return
+	}
+
+	queue, err := Queue(user, change)
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+	if service.RequiresAuth() {
+		queue = service.SignQueue(queue, user)
+	}
+	jsonWrite(w, queue)
+}
+
+// v1PlayerHandler returns or controls the player of the requesting user, ex: POST /v1/player/seek?position=30
+func v1PlayerHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := service.Auth(getAccessKey(r))
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+	command := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/player"), "/")
+	if command != "" && r.Method != http.MethodPost {
+		jsonWriteErrorf(w, 405, "player: %s needs a POST request", command)
+		return
+	}
+	query := r.URL.Query()
+
+	var control func(player *PlayerState, view []*Object) error
+	switch command {
+	case "":
+	case "play":
+		control = func(player *PlayerState, view []*Object) error {
+			if query.Get("index") == "" {
+				return player.Resume(view)
 			}
+			index, err := strconv.Atoi(query.Get("index"))
+			if err != nil {
+				return NewError(ErrBadURI, "", "player: need index to play")
+			}
+			return player.Play(index, view)
+		}
+	case "pause":
+		control = func(player *PlayerState, view []*Object) error {
+			player.Pause()
+			return nil
+		}
+	case "seek":
+		position, err := strconv.ParseFloat(query.Get("position"), 64)
+		if err != nil {
+			jsonWriteError(w, NewError(ErrBadURI, "", "player: need position to seek to"))
+			return
+		}
+		control = func(player *PlayerState, view []*Object) error {
+			return player.Seek(position)
+		}
+	case "next":
+		control = func(player *PlayerState, view []*Object) error {
+			player.Next(view)
+			return nil
+		}
+	case "prev":
+		control = func(player *PlayerState, view []*Object) error {
+			player.Prev(view)
+			return nil
+		}
+	case "volume":
+		volume, err := strconv.Atoi(query.Get("level"))
+		if err != nil {
+			jsonWriteError(w, NewError(ErrBadURI, "", "player: need level to set the volume to"))
+			return
+		}
+		control = func(player *PlayerState, view []*Object) error {
+			return player.SetVolume(volume)
 		}
-		if !streamed {
-			jsonWriteErrorf(w, 500, "libremedia: all formats available to select from matched stream object were null")
+	case "repeat":
+		control = func(player *PlayerState, view []*Object) error {
+			return player.SetRepeat(query.Get("mode"))
+		}
+	case "shuffle":
+		shuffle, err := strconv.ParseBool(query.Get("enabled"))
+		if err != nil {
+			jsonWriteError(w, NewError(ErrBadURI, "", "player: need enabled=true or enabled=false to shuffle"))
+			return
+		}
+		//The player finds the playing stream again within the new order
+		if _, err := Queue(user, func(queue *ObjectQueue) error {
+			queue.SetShuffle(shuffle)
+			return nil
+		}); err != nil {
+			jsonWriteError(w, err)
 			return
 		}
+	default:
+		jsonWriteError(w, NewError(ErrBadURI, "", "player: unknown command %s", command))
+		return
 	}
-	return
+
+	player, err := Player(user, control)
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+	if service.RequiresAuth() {
+		player = service.SignPlayer(player, user)
+	}
+	jsonWrite(w, player)
 }
 
-func v1StreamHandler(w http.ResponseWriter, r *http.Request) {
-	settings := r.URL.Query()
+// v1SessionsHandler starts a shared session with a POST to /v1/sessions, returns its state from /v1/sessions/<id>, and joins it over a WebSocket at /v1/sessions/<id>/ws
+func v1SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := service.Auth(getAccessKey(r))
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/sessions"), "/"), "/")
+	if path[0] == "" {
+		if r.Method != http.MethodPost {
+			jsonWriteErrorf(w, 405, "sessions: starting a session needs a POST request")
+			return
+		}
+		voteSkip, _ := strconv.ParseBool(query.Get("voteSkip"))
+		session, err := NewSession(user, voteSkip, query.Get("append"))
+		if err != nil {
+			jsonWriteError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(session.HostState())
+		return
+	}
+
+	session := GetSession(path[0])
+	if session == nil {
+		jsonWriteError(w, NewError(ErrNotFound, "", "sessions: no session %s", path[0]))
+		return
+	}
+	switch {
+	case len(path) == 1:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(session.State())
+	case len(path) == 2 && path[1] == "ws":
+		websocket.Handler(func(ws *websocket.Conn) {
+			session.Serve(ws, query.Get("host"), query.Get("mode"))
+		}).ServeHTTP(w, r)
+	default:
+		jsonWriteError(w, NewError(ErrBadURI, "", "sessions: need /v1/sessions/<id> or /v1/sessions/<id>/ws"))
+	}
+}
+
+// v1BestMatchHandler lists every result of a bestmatch query with its score, ex: /v1/bestmatch/creator:daft punk
+func v1BestMatchHandler(w http.ResponseWriter, r *http.Request) {
+	uri, err := ParseURI("bestmatch:" + requestURI(r, "/v1/bestmatch/"))
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+	if uri.ID == "" {
+		jsonWriteError(w, NewError(ErrBadURI, "", "bestmatch: need query"))
+		return
+	}
+	uri.SetParams(r.URL.Query())
+	scores, searchResultsObj := service.BestMatch(uri.ID, uri.Params)
+	if searchResultsObj != nil {
+		if objErr := searchResultsObj.Err(); objErr != nil {
+			jsonWriteStatus(w, objErr.Status(), searchResultsObj)
+			return
+		}
+	}
+	jsonWrite(w, scores)
+}
+
+// v1TranscriptHandler serves the transcript of a stream, either as JSON or rendered with ?format=lrc, vtt, srt or ttml, ex: /v1/transcript/tidal:track:1234?format=vtt
+func v1TranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	uri, err := ParseURI(requestURI(r, "/v1/transcript/"))
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+	uri.Sub = "transcript"
+	uri.SubArgs = nil
+	if granularity := r.URL.Query().Get("granularity"); granularity != "" {
+		uri.Params.Set("granularity", granularity)
+	}
+	obj := GetObject(uri.String())
+	if obj == nil {
+		jsonWriteErrorf(w, 404, "no matching transcript")
+		return
+	}
+	if objErr := obj.Err(); objErr != nil {
+		jsonWriteStatus(w, objErr.Status(), obj)
+		return
+	}
+	transcript := obj.Transcript()
+	if transcript == nil {
+		jsonWriteError(w, NewError(ErrNotFound, obj.Provider, "no transcript for %s", uri.Base().String()))
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" || format == "json" {
+		jsonWrite(w, obj)
+		return
+	}
+	durationMs := 0
+	if base := GetObject(uri.Base().String()); base != nil {
+		if stream := base.Stream(); stream != nil {
+			durationMs = int(stream.Duration) * 1000
+		}
+	}
+	data, contentType, err := transcript.Export(format, durationMs)
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*") //Needed for <track> elements on other origins
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// v1ProviderIconHandler redirects to the upstream icon of a provider, ex: /v1/providers/tidal/icon
+func v1ProviderIconHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/providers/"), "/")
+	if len(path) != 2 || path[1] != "icon" {
+		jsonWriteError(w, NewError(ErrBadURI, "", "providers: need /v1/providers/<provider>/icon"))
+		return
+	}
+	handler, exists := GetHandler(path[0])
+	if !exists {
+		jsonWriteError(w, NewError(ErrNotFound, path[0], "providers: no provider %s", path[0]))
+		return
+	}
+	http.Redirect(w, r, handler.About().Icon, http.StatusFound)
+}
+
+func v1AdminRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if !service.IsAdmin(getAccessKey(r)) {
+		jsonWriteErrorf(w, 401, "admin: invalid access key")
+		return
+	}
+	uri := requestURI(r, "/v1/admin/refresh/")
+	if uri == "" {
+		jsonWriteErrorf(w, 400, "admin: need uri to refresh")
+		return
+	}
+	if IsRefreshing(uri) {
+		jsonWriteErrorf(w, 409, "admin: %s is already refreshing", uri)
+		return
+	}
+	obj := RefreshObject(uri)
+	if obj == nil {
+		jsonWriteErrorf(w, 404, "no matching object")
+		return
+	}
+	if objErr := obj.Err(); objErr != nil {
+		jsonWriteStatus(w, objErr.Status(), obj)
+		return
+	}
+	go obj.Expand()
+	jsonWrite(w, obj)
+}
+
+func v1DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	mediaURI := requestURI(r, "/v1/download/")
+	user, err := service.AuthStream(r, mediaURI)
+	if err != nil {
+		jsonWriteError(w, err)
+		return
+	}
 
-	path := strings.Split(r.URL.Path[11:], "?")
-	objectStream := GetObjectLive(path[0])
+	objectStream := GetStreamLive(mediaURI)
 	if objectStream == nil {
 		jsonWriteErrorf(w, 404, "no matching stream object")
 		return
 	}
+	if objErr := objectStream.Err(); objErr != nil {
+		jsonWriteStatus(w, objErr.Status(), objectStream)
+		return
+	}
 	if objectStream.Type != "stream" {
 		jsonWriteErrorf(w, 404, "no matching stream object")
 		return
@@ -202,50 +613,67 @@ func v1StreamHandler(w http.ResponseWriter, r *http.Request) {
 		jsonWriteErrorf(w, 500, "unable to process stream object")
 		return
 	}
-	formatCfg := settings.Get("format")
-	if formatCfg == "" {
-		formatCfg = "0"
-	}
-	formatNum, err := strconv.Atoi(formatCfg)
+	downloaded, err := service.ServeStream(w, r, objectStream, user, service.Download)
 	if err != nil {
-		jsonWriteErrorf(w, 500, "libremedia: format selection unavailable")
+		jsonWriteError(w, err)
 		return
 	}
+	stats.Downloaded(r, user, downloaded.Stream())
+	return
+}
 
-	err = service.Stream(w, r, stream, formatNum)
-	if err != nil {
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "broken pipe") || strings.Contains(errMsg, "connection reset") {
-			return //The stream was successful, but interrupted
-		}
-		if settings.Get("format") != "" {
-			jsonWriteErrorf(w, 500, "libremedia: format selection unavailable for matched stream object")
+func v1StreamHandler(w http.ResponseWriter, r *http.Request) {
+	//A bare request streams whatever the user's player says is playing
+	var user *ServiceUser
+	var err error
+	mediaURI := strings.TrimPrefix(requestURI(r, "/v1/stream"), "/")
+	if mediaURI == "" {
+		user, err = service.Auth(getAccessKey(r))
+		if err != nil {
+			jsonWriteError(w, err)
 			return
 		}
-		if len(stream.Formats) == 0 {
-			jsonWriteErrorf(w, 404, "libremedia: no formats available to select from for matched stream object")
+		player, err := Player(user, nil)
+		if err != nil {
+			jsonWriteError(w, err)
 			return
 		}
-		streamed := false
-		for i := formatNum; i < len(stream.Formats); i++ {
-			if stream.Formats[i] != nil {
-				Trace.Println("Selecting format " + stream.Formats[i].Name + " automatically")
-				err = service.Stream(w, r, stream, i)
-				if err != nil {
-					errMsg = err.Error()
-					if strings.Contains(errMsg, "broken pipe") || strings.Contains(errMsg, "connection reset") {
-						return //The stream was successful, but interrupted
-					}
-					continue
-				}
-				streamed = true
-				break
-			}
-		}
-		if !streamed {
-			jsonWriteErrorf(w, 500, "libremedia: all formats available to select from matched stream object were null")
+		if player.URI == "" {
+			jsonWriteError(w, NewError(ErrNotFound, "", "player: nothing is playing"))
 			return
 		}
+		mediaURI = player.URI
+	} else if user, err = service.AuthStream(r, mediaURI); err != nil {
+		jsonWriteError(w, err)
+		return
+	}
+
+	objectStream := GetStreamLive(mediaURI)
+	if objectStream == nil {
+		jsonWriteErrorf(w, 404, "no matching stream object")
+		return
+	}
+	if objErr := objectStream.Err(); objErr != nil {
+		jsonWriteStatus(w, objErr.Status(), objectStream)
+		return
+	}
+	if objectStream.Type != "stream" {
+		jsonWriteErrorf(w, 404, "no matching stream object")
+		return
+	}
+	stream := objectStream.Stream()
+	if stream == nil {
+		jsonWriteErrorf(w, 500, "unable to process stream object")
+		return
+	}
+
+	//Count the bytes served by every attempt, so a listen is counted however many Range requests it takes
+	served := &statsWriter{ResponseWriter: w}
+	servedStream, err := service.ServeStream(served, r, objectStream, user, service.Stream)
+	stats.Served(r, user, servedStream.Stream(), served.served, served.size)
+	if err != nil {
+		jsonWriteError(w, err)
+		return
 	}
 	return
 }
@@ -357,10 +785,35 @@ func gid2id(w http.ResponseWriter, r *http.Request) {
 }*/
 
 func jsonWrite(w http.ResponseWriter, data interface{}) {
+	jsonWriteStatus(w, 200, data)
+}
+
+// jsonWriteError writes an error with the HTTP status code that matches it
+func jsonWriteError(w http.ResponseWriter, err error) {
+	objErr, ok := err.(*ObjectError)
+	if !ok {
+		objErr = WrapError(err, "", "libremedia")
+	}
+	jsonWriteStatus(w, objErr.Status(), objErr)
+}
+
+func jsonWriteStatus(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	//Allow marshalling special cases
 	switch typedData := data.(type) {
+	case *ObjectError:
+		json, err := json.Marshal(typedData)
+		if err != nil {
+			Error.Println("Could not marshal data [ ", err, " ]:", data)
+			jsonWriteErrorf(w, 500, "could not prep data")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write(json)
+		Error.Printf("Sent error %d: %v\n", statusCode, typedData.Error())
 	case error:
 		json, err := json.Marshal(&exporterr{Error: typedData.Error()})
 		if err != nil {
@@ -370,6 +823,7 @@ func jsonWrite(w http.ResponseWriter, data interface{}) {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
 		w.Write(json)
 		Error.Printf("Sent error: %v\n", typedData.Error())
 	default:
@@ -381,9 +835,11 @@ func jsonWrite(w http.ResponseWriter, data interface{}) {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
 		w.Write(json)
 	}
 }
+
 func jsonWriteErrorf(w http.ResponseWriter, statusCode int, error string, data ...interface{}) {
 	errMsg := fmt.Errorf(error, data...)
 
@@ -397,13 +853,26 @@ func jsonWriteErrorf(w http.ResponseWriter, statusCode int, error string, data .
 		return
 	}
 
-	w.WriteHeader(statusCode)
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(statusCode)
 	w.Write(json)
 	Error.Printf("Sent error %d: %v\n", statusCode, errMsg)
 }
 
+// requestURI returns the libremedia URI requested after the given path prefix, keeping its escaping intact
+func requestURI(r *http.Request, prefix string) string {
+	return strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+}
+
+// getAccessKey returns the access key provided with a request, either as a bearer token or the accessKey param
+func getAccessKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return auth[7:]
+	}
+	return r.URL.Query().Get("accessKey")
+}
+
 func getRemote(r *http.Request) string {
 	userAgent := r.Header.Get("User-Agent")
 
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	initLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	service = &Service{BaseURL: "http://localhost/"}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/* Cache schema versioning:
+
+Every object written to the cache is stamped with cacheSchema. Bump it whenever a change to an object
+struct would be misread from records written before the change, and register a migration that upgrades
+records from the previous version. Records are upgraded one version at a time when they're loaded, and
+written back so each record only migrates once. Records that can't be migrated are dropped and refetched.
+Records written by a newer build are left alone and treated as a cache miss, so rolling back keeps the cache.
+*/
+
+const (
+	cacheSchema = 2 //The schema version of objects written to the cache by this build
+)
+
+var errSchemaNewer = errors.New("cache record is from a newer schema")
+
+// CacheMigration upgrades a decoded cache record in place from one schema version to the next
+type CacheMigration func(record map[string]interface{}) error
+
+var (
+	//Migrations that upgrade a record from the keyed version to the next one
+	cacheMigrations = map[int]CacheMigration{
+		0: migrateUnversioned,
+		1: migrateStreamFormats,
+	}
+)
+
+// migrateUnversioned upgrades records from before schema versioning, which already match schema 1
+func migrateUnversioned(record map[string]interface{}) error {
+	return nil
+}
+
+// migrateStreamFormats relabels Tidal's AAC formats, which were cached as FLAC, and expires any record holding a stream
+// Streams cached before schema 2 lack ISRC and UPC codes, and list Spotify formats by position rather than by file format
+func migrateStreamFormats(record map[string]interface{}) error {
+	if migrateFormats(record, "") {
+		record["expires"] = time.Now().Format(time.RFC3339Nano)
+	}
+	return nil
+}
+
+// migrateFormats walks a decoded record, relabelling the formats of each Tidal stream, and returns true if it found any stream
+func migrateFormats(value interface{}, provider string) bool {
+	found := false
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if name, ok := value["provider"].(string); ok && name != "" {
+			provider = name
+		}
+		if formats, ok := value["formats"].([]interface{}); ok {
+			found = true
+			for i := 0; i < len(formats); i++ {
+				format, ok := formats[i].(map[string]interface{})
+				if !ok || provider != "tidal" {
+					continue
+				}
+				if name := format["name"]; name == "HIGH" || name == "LOW" {
+					format["format"] = "mp4"
+					format["codec"] = "aac"
+				}
+			}
+		}
+		for key, child := range value {
+			if key != "formats" && migrateFormats(child, provider) {
+				found = true
+			}
+		}
+	case []interface{}:
+		for i := 0; i < len(value); i++ {
+			if migrateFormats(value[i], provider) {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// migrateRecord upgrades a decoded cache record in place from the given schema version to the current one
+func migrateRecord(record map[string]interface{}, version int) error {
+	for ; version < cacheSchema; version++ {
+		migrate, exists := cacheMigrations[version]
+		if !exists {
+			return fmt.Errorf("no migration from schema %d", version)
+		}
+		if err := migrate(record); err != nil {
+			return fmt.Errorf("migrating from schema %d: %v", version, err)
+		}
+	}
+	record["schema"] = cacheSchema
+	return nil
+}
+
+// MigrateObjectCache returns a cached record upgraded to the current schema, and whether or not it changed
+func MigrateObjectCache(objData []byte) ([]byte, bool, error) {
+	header := struct {
+		Schema int `json:"schema"`
+	}{}
+	if err := json.Unmarshal(objData, &header); err != nil {
+		return nil, false, err
+	}
+	if header.Schema == cacheSchema {
+		return objData, false, nil
+	}
+	if header.Schema > cacheSchema {
+		return nil, false, fmt.Errorf("%w: %d is newer than %d", errSchemaNewer, header.Schema, cacheSchema)
+	}
+
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(objData, &record); err != nil {
+		return nil, false, err
+	}
+	if err := migrateRecord(record, header.Schema); err != nil {
+		return nil, false, err
+	}
+
+	objData, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, err
+	}
+	return objData, true, nil
+}
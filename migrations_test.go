@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMigrateRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		record  string
+		want    string
+		expired bool
+	}{
+		{
+			name:    "unversioned artwork",
+			version: 0,
+			record:  `{"uri":"tidal:album:1:artwork","type":"artwork","object":{"url":"https://example.com/1.jpg"}}`,
+			want:    `{"uri":"tidal:album:1:artwork","type":"artwork","object":{"url":"https://example.com/1.jpg"},"schema":2}`,
+		},
+		{
+			name:    "tidal stream",
+			version: 1,
+			record:  `{"uri":"tidal:track:1","type":"stream","provider":"tidal","object":{"formats":[{"id":1,"name":"LOSSLESS","format":"flac","codec":"flac"},{"id":2,"name":"HIGH","format":"flac","codec":"flac"},{"id":3,"name":"LOW","format":"flac","codec":"flac"}]}}`,
+			want:    `{"uri":"tidal:track:1","type":"stream","provider":"tidal","object":{"formats":[{"id":1,"name":"LOSSLESS","format":"flac","codec":"flac"},{"id":2,"name":"HIGH","format":"mp4","codec":"aac"},{"id":3,"name":"LOW","format":"mp4","codec":"aac"}]},"schema":2}`,
+			expired: true,
+		},
+		{
+			name:    "album holding a tidal stream",
+			version: 1,
+			record:  `{"type":"album","provider":"tidal","object":{"discs":[{"streams":[{"type":"stream","provider":"tidal","object":{"formats":[{"name":"HIGH","codec":"flac"}]}}]}]}}`,
+			want:    `{"type":"album","provider":"tidal","object":{"discs":[{"streams":[{"type":"stream","provider":"tidal","object":{"formats":[{"name":"HIGH","format":"mp4","codec":"aac"}]}}]}]},"schema":2}`,
+			expired: true,
+		},
+		{
+			name:    "spotify stream",
+			version: 0,
+			record:  `{"type":"stream","provider":"spotify","object":{"formats":[{"id":2,"name":"HIGH","codec":"vorbis"}]}}`,
+			want:    `{"type":"stream","provider":"spotify","object":{"formats":[{"id":2,"name":"HIGH","codec":"vorbis"}]},"schema":2}`,
+			expired: true,
+		},
+		{
+			name:    "transcript",
+			version: 1,
+			record:  `{"type":"transcript","provider":"tidal","object":{"lines":[{"text":"hi"}]}}`,
+			want:    `{"type":"transcript","provider":"tidal","object":{"lines":[{"text":"hi"}]},"schema":2}`,
+		},
+	}
+	for _, test := range tests {
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(test.record), &record); err != nil {
+			t.Fatalf("%s: bad test record: %v", test.name, err)
+		}
+		before := time.Now()
+		if err := migrateRecord(record, test.version); err != nil {
+			t.Errorf("%s: migrateRecord returned error: %v", test.name, err)
+			continue
+		}
+
+		expires, expired := record["expires"].(string)
+		if expired != test.expired {
+			t.Errorf("%s: expired = %v, want %v", test.name, expired, test.expired)
+		}
+		if expired {
+			expiry, err := time.Parse(time.RFC3339Nano, expires)
+			if err != nil || expiry.Before(before) || expiry.After(time.Now()) {
+				t.Errorf("%s: expires = %s, want the time of migration", test.name, expires)
+			}
+			delete(record, "expires")
+		}
+		//Compare as decoded JSON, since the migrated schema is an int rather than a float64
+		got, _ := json.Marshal(record)
+		gotRecord, want := make(map[string]interface{}), make(map[string]interface{})
+		json.Unmarshal(got, &gotRecord)
+		json.Unmarshal([]byte(test.want), &want)
+		if !reflect.DeepEqual(gotRecord, want) {
+			t.Errorf("%s: migrated to %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	if err := migrateRecord(map[string]interface{}{}, -1); err == nil {
+		t.Errorf("migrated from a schema with no migration, want error")
+	}
+}
+
+func TestMigrateObjectCache(t *testing.T) {
+	current := []byte(`{"uri":"tidal:track:1","type":"stream","schema":2}`)
+	data, migrated, err := MigrateObjectCache(current)
+	if err != nil || migrated || string(data) != string(current) {
+		t.Errorf("current record = %s, %v, %v, want it unchanged", data, migrated, err)
+	}
+
+	data, migrated, err = MigrateObjectCache([]byte(`{"uri":"tidal:album:1:artwork","type":"artwork"}`))
+	if err != nil || !migrated {
+		t.Errorf("unversioned record = %s, %v, %v, want it migrated", data, migrated, err)
+	}
+
+	if _, _, err = MigrateObjectCache([]byte(`{"schema":3}`)); err == nil {
+		t.Errorf("newer record migrated, want error")
+	}
+}
+
+func TestReadObjectCacheNewerSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libremedia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//A record from a newer build is a cache miss, but it must survive for when that build runs again
+	path := filepath.Join(dir, "1.json")
+	newer := []byte(`{"uri":"tidal:track:1","type":"stream","schema":99}`)
+	if err := ioutil.WriteFile(path, newer, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if obj := readObjectCache("tidal:track:1", path); obj != nil {
+		t.Errorf("read %+v from a newer schema, want a cache miss", obj)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != string(newer) {
+		t.Errorf("newer record became %s, %v, want it left alone", data, err)
+	}
+
+	//Records from this build are read, and served without their schema
+	current := []byte(`{"uri":"tidal:track:1","type":"stream","object":{"name":"x"},"schema":2}`)
+	ioutil.WriteFile(path, current, 0644)
+	obj := readObjectCache("tidal:track:1", path)
+	if obj == nil || obj.Stream() == nil || obj.Schema != cacheSchema {
+		t.Fatalf("read %+v, want the cached stream", obj)
+	}
+	if data, _ := json.Marshal(obj); string(data) != `{"uri":"tidal:track:1","type":"stream","object":{"name":"x"}}` {
+		t.Errorf("served %s, want no schema", data)
+	}
+}
@@ -17,6 +17,8 @@ type ObjectAlbum struct {
 	DateTime    string           `json:"datetime,omitempty"`    //The release date of this album
 	Creators    []*Object        `json:"creators,omitempty"`    //The creators of this album
 	Explicit    bool             `json:"explicit,omitempty"`    //Whether or not this album contains explicit tracks
+	UPC         string           `json:"upc,omitempty"`         //The Universal Product Code of this album
+	Popularity  int              `json:"popularity,omitempty"`  //How popular this album is on its provider, from 0 to 100
 }
 
 func (obj *ObjectAlbum) JSON() []byte {
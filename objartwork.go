@@ -23,4 +23,28 @@ func (obj *ObjectArtwork) JSON() []byte {
 
 func NewObjArtwork(provider, fileType, url string, width, height int) *ObjectArtwork {
 	return &ObjectArtwork{provider, width, height, url, fileType}
-}
\ No newline at end of file
+}
+
+// BestArtwork returns the smallest artwork of the given file type at or above the given size, or the largest if none are big enough
+func BestArtwork(artworks []*ObjectArtwork, size int, fileType string) (best *ObjectArtwork) {
+	for i := 0; i < len(artworks); i++ {
+		artwork := artworks[i]
+		if artwork == nil || (fileType != "" && artwork.Type != fileType) {
+			continue
+		}
+		if best == nil {
+			best = artwork
+			continue
+		}
+		if size > 0 && artwork.Width >= size {
+			if best.Width < size || artwork.Width < best.Width {
+				best = artwork
+			}
+		} else if best.Width < size || size <= 0 {
+			if artwork.Width > best.Width {
+				best = artwork
+			}
+		}
+	}
+	return best
+}
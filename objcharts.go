@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// ObjectCharts holds the most played or downloaded streams over a window of time
+type ObjectCharts struct {
+	Chart   string              `json:"chart,omitempty"`   //The chart these entries rank, ex: streams, downloads
+	Window  string              `json:"window,omitempty"`  //The window of time these entries were counted over, ex: day, week, all
+	Entries []*ObjectChartEntry `json:"entries,omitempty"` //The ranked entries, from most to least counted
+}
+
+// ObjectChartEntry holds a ranked stream and how many times it was counted
+type ObjectChartEntry struct {
+	Count  int64   `json:"count"`            //How many times this stream was counted within the window
+	Object *Object `json:"object,omitempty"` //The stream that was counted
+}
+
+func (obj *ObjectCharts) JSON() []byte {
+	objJSON, err := json.Marshal(obj)
+	if err != nil {
+		return nil
+	}
+	return objJSON
+}
@@ -20,6 +20,7 @@ type ObjectCreator struct {
 	Singles     []*Object        `json:"singles,omitempty"`     //The single streams from this creator
 	Playlists   []*Object        `json:"playlists,omitempty"`   //The playlists from this creator
 	Related     []*Object        `json:"related,omitempty"`     //The creators related to this creator
+	Popularity  int              `json:"popularity,omitempty"`  //How popular this creator is on its provider, from 0 to 100
 }
 
 func (obj *ObjectCreator) JSON() []byte {
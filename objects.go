@@ -2,22 +2,70 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
-	"strings"
+	"path/filepath"
 	"time"
 )
 
 // Object holds a metadata object
 type Object struct {
-	URI       string           `json:"uri,omitempty"`       //The URI that matches this object
-	Type      string           `json:"type,omitempty"`      //search, stream, creator, album
-	Provider  string           `json:"provider,omitempty"`  //The service that provides this object
-	Expires   *time.Time       `json:"expires,omitempty"`   //When this object should expire by
-	LastMod   *time.Time       `json:"lastMod,omitempty"`   //When this object was last altered
-	Object    *json.RawMessage `json:"object,omitempty"`    //Holds either the raw object or a string containing the object's reference URI
-	Expanding bool             `json:"expanding,omitempty"` //Whether or not this object is in the process of internal expansion
-	Expanded  bool             `json:"expanded,omitempty"`  //Whether or not this object has been expanded internally
+	URI       string     `json:"uri,omitempty"`       //The URI that matches this object
+	Type      string     `json:"type,omitempty"`      //search, stream, creator, album, playlist, artwork, transcript, charts, error
+	Provider  string     `json:"provider,omitempty"`  //The service that provides this object
+	Expires   *time.Time `json:"expires,omitempty"`   //When this object should expire by
+	LastMod   *time.Time `json:"lastMod,omitempty"`   //When this object was last altered
+	Object    ObjectData `json:"object,omitempty"`    //Holds the typed payload that matches this object's type
+	Expanding bool       `json:"expanding,omitempty"` //Whether or not this object is in the process of internal expansion
+	Expanded  bool       `json:"expanded,omitempty"`  //Whether or not this object has been expanded internally
+	Stale     bool       `json:"stale,omitempty"`     //Whether or not this object was served from the cache after it expired
+	Schema    int        `json:"-"`                   //The cache schema version this object was written with, only stored in the cache
+
+	Alternatives []string          `json:"alternatives,omitempty"` //The URIs of this same object on other providers, ex: when merging search results
+	Sources      map[string]string `json:"sources,omitempty"`      //The provider each enriched field was filled in from, ex: description: tidal
+	Enriched     bool              `json:"enriched,omitempty"`     //Whether or not other providers have been searched for missing metadata
+}
+
+// ObjectData is the typed payload held by an object, only encoded to JSON when served or cached
+type ObjectData interface {
+	JSON() []byte
+}
+
+// objectRaw holds the payload of an unknown object type as-is, so it survives a trip through the cache
+type objectRaw json.RawMessage
+
+func (raw objectRaw) JSON() []byte {
+	return raw
+}
+
+func (raw objectRaw) MarshalJSON() ([]byte, error) {
+	return raw, nil
+}
+
+// newObjectData returns an empty payload for the given object type, or nil if the type is unknown
+func newObjectData(objType string) ObjectData {
+	switch cacheType(objType) {
+	case "search":
+		return &ObjectSearchResults{}
+	case "creator":
+		return &ObjectCreator{}
+	case "album":
+		return &ObjectAlbum{}
+	case "stream":
+		return &ObjectStream{}
+	case "artwork":
+		return &ObjectArtwork{}
+	case "transcript":
+		return &ObjectTranscript{}
+	case "charts":
+		return &ObjectCharts{}
+	case "playlist":
+		return &ObjectPlaylist{}
+	case "error":
+		return &ObjectError{}
+	}
+	return nil
 }
 
 // JSON returns this object as serialized JSON
@@ -25,68 +73,90 @@ func (obj *Object) JSON() ([]byte, error) {
 	return json.Marshal(obj)
 }
 
-func (obj *Object) SearchResults() *ObjectSearchResults {
-	if obj.Object == nil {
+// UnmarshalJSON decodes an object, mapping its payload into the typed struct that matches its type
+func (obj *Object) UnmarshalJSON(data []byte) error {
+	type object Object
+	raw := struct {
+		*object
+		Object json.RawMessage `json:"object,omitempty"`
+	}{object: (*object)(obj)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	obj.Object = nil
+	if len(raw.Object) == 0 || string(raw.Object) == "null" {
 		return nil
 	}
-	switch obj.Type {
-	case "search":
-		ret := &ObjectSearchResults{}
-		if objJSON, err := obj.Object.MarshalJSON(); err == nil {
-			if err := json.Unmarshal(objJSON, &ret); err == nil {
-				return ret
-			}
-		}
+	payload := newObjectData(obj.Type)
+	if payload == nil {
+		obj.Object = objectRaw(raw.Object)
+		return nil
 	}
+	if err := json.Unmarshal(raw.Object, payload); err != nil {
+		return err
+	}
+	obj.Object = payload
 	return nil
 }
 
+// SearchResults returns the search results held by a search object
+func (obj *Object) SearchResults() *ObjectSearchResults {
+	ret, _ := obj.Object.(*ObjectSearchResults)
+	return ret
+}
+
+// Creator returns the creator held by a creator object
 func (obj *Object) Creator() *ObjectCreator {
-	if obj.Object == nil {
-		return nil
-	}
-	switch obj.Type {
-	case "artist", "creator", "user", "channel", "chan", "streamer":
-		ret := &ObjectCreator{}
-		if objJSON, err := obj.Object.MarshalJSON(); err == nil {
-			if err := json.Unmarshal(objJSON, &ret); err == nil {
-				return ret
-			}
-		}
-	}
-	return nil
+	ret, _ := obj.Object.(*ObjectCreator)
+	return ret
 }
 
+// Album returns the album held by an album object
 func (obj *Object) Album() *ObjectAlbum {
-	if obj.Object == nil {
-		return nil
-	}
-	switch obj.Type {
-	case "album":
-		ret := &ObjectAlbum{}
-		if objJSON, err := obj.Object.MarshalJSON(); err == nil {
-			if err := json.Unmarshal(objJSON, &ret); err == nil {
-				return ret
-			}
-		}
-	}
-	return nil
+	ret, _ := obj.Object.(*ObjectAlbum)
+	return ret
 }
 
+// Stream returns the stream held by a stream object
 func (obj *Object) Stream() *ObjectStream {
-	if obj.Object == nil {
-		return nil
-	}
-	switch obj.Type {
-	case "track", "song", "video", "audio", "stream":
-		ret := &ObjectStream{}
-		if objJSON, err := obj.Object.MarshalJSON(); err == nil {
-			if err := json.Unmarshal(objJSON, &ret); err == nil {
-				return ret
-			}
-		}
-	}
-	return nil
+	ret, _ := obj.Object.(*ObjectStream)
+	return ret
+}
+
+// Artwork returns the artwork held by an artwork object
+func (obj *Object) Artwork() *ObjectArtwork {
+	ret, _ := obj.Object.(*ObjectArtwork)
+	return ret
+}
+
+// Transcript returns the transcript held by a transcript object
+func (obj *Object) Transcript() *ObjectTranscript {
+	ret, _ := obj.Object.(*ObjectTranscript)
+	return ret
+}
+
+// Playlist returns the playlist held by a playlist object
+func (obj *Object) Playlist() *ObjectPlaylist {
+	ret, _ := obj.Object.(*ObjectPlaylist)
+	return ret
+}
+
+// Charts returns the ranked streams held by a charts object
+func (obj *Object) Charts() *ObjectCharts {
+	ret, _ := obj.Object.(*ObjectCharts)
+	return ret
+}
+
+// Err returns the structured error held by an error object
+func (obj *Object) Err() *ObjectError {
+	ret, _ := obj.Object.(*ObjectError)
+	return ret
+}
+
+// IsExpired returns true if this object is no longer fresh and should be refreshed
+func (obj *Object) IsExpired() bool {
+	return obj.Expires != nil && time.Now().After(*obj.Expires)
 }
 
 // Sync writes this object to the cache
@@ -94,47 +164,70 @@ func (obj *Object) Sync() {
 	if obj.URI == "" {
 		return
 	}
-	expiryTime := time.Now()
-	switch obj.Type {
-	case "search": //2 hours
+	switch cacheType(obj.Type) {
+	case "search":
+		//Partial results are searched again instead of cached
 		objSearch := obj.SearchResults()
-		if objSearch != nil && objSearch.IsEmpty() {
+		if objSearch != nil && (objSearch.IsEmpty() || len(objSearch.Errors) > 0) {
 			return
 		}
-		expiryTime = expiryTime.Add(time.Hour * 2)
-	case "artist", "creator", "user", "channel", "chan", "streamer": //12 hours
+	case "creator":
 		objCreator := obj.Creator()
 		if objCreator != nil && objCreator.IsEmpty() {
 			return
 		}
-		expiryTime = expiryTime.Add(time.Hour * 12)
-	case "album", "track", "song", "video", "audio", "stream": //30 days
+	case "album", "stream":
 		objAlbum := obj.Album()
 		if objAlbum != nil && objAlbum.IsEmpty() {
 			return
 		}
-		expiryTime = expiryTime.Add(time.Hour * (24 * 30))
 	}
-	obj.LastMod = &expiryTime
+	lastMod := time.Now()
+	expiryTime := lastMod.Add(service.Cache.TTL(obj.Type))
+	obj.LastMod = &lastMod
 	obj.Expires = &expiryTime
+	obj.Stale = false
+	obj.Schema = cacheSchema
 
-	objData, err := obj.JSON()
+	//The schema version is only written to the cache, never served
+	objData, err := json.Marshal(struct {
+		*Object
+		Schema int `json:"schema"`
+	}{obj, obj.Schema})
 	if err != nil {
 		return
 	}
-	splitURI := strings.Split(obj.URI, ":")
-	pathURL := "cache/"
-	fileName := ""
-	for i := 0; i < len(splitURI); i++ {
-		if i == len(splitURI)-1 {
-			fileName = splitURI[i] + ".json"
-			break
-		}
-		pathURL += splitURI[i] + "/"
+	uri, err := ParseURI(obj.URI)
+	if err != nil {
+		return
+	}
+	pathURL := uri.Path()
+	os.MkdirAll(filepath.Dir(pathURL), 0777)
+	if err := writeFileAtomic(pathURL, objData, 0777); err != nil {
+		Error.Printf("Failed to cache %s: %v\n", obj.URI, err)
+	}
+}
+
+// writeFileAtomic replaces a file by writing a temporary file next to it and renaming it into place, so readers never see a partial write
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
 	}
-	os.MkdirAll(pathURL, 0777)
-	pathURL += fileName
-	ioutil.WriteFile(pathURL, objData, 0777)
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
 }
 
 // Expand fills in all top-level object arrays with completed objects
@@ -154,127 +247,112 @@ func (src *Object) Expand() {
 	switch src.Type {
 	case "search":
 		if search := src.SearchResults(); search != nil {
-			syncSearch := func() {
-				searchJSON, err := json.Marshal(search)
-				if err == nil {
-					src.Object = &json.RawMessage{}
-					src.Object.UnmarshalJSON(searchJSON)
-					src.Sync()
-				}
-			}
 			for i := 0; i < len(search.Streams); i++ {
 				if search.Streams[i].URI == "" {
 					continue
 				}
-				search.Streams[i] = GetObject(search.Streams[i].URI)
-				syncSearch()
+				search.Streams[i] = expandObject(search.Streams[i])
+				src.Sync()
 			}
 			for i := 0; i < len(search.Creators); i++ {
 				if search.Creators[i].URI == "" {
 					continue
 				}
-				search.Creators[i] = GetObject(search.Creators[i].URI)
-				syncSearch()
+				search.Creators[i] = expandObject(search.Creators[i])
+				src.Sync()
 			}
 			for i := 0; i < len(search.Albums); i++ {
 				if search.Albums[i].URI == "" {
 					continue
 				}
-				search.Albums[i] = GetObject(search.Albums[i].URI)
-				syncSearch()
+				search.Albums[i] = expandObject(search.Albums[i])
+				src.Sync()
 			}
 		}
 	case "artist", "creator", "user", "channel", "chan", "streamer":
 		if creator := src.Creator(); creator != nil {
-			syncCreator := func() {
-				creatorJSON, err := json.Marshal(creator)
-				if err == nil {
-					src.Object = &json.RawMessage{}
-					src.Object.UnmarshalJSON(creatorJSON)
-					src.Sync()
-				}
-			}
 			for i := 0; i < len(creator.TopStreams); i++ {
 				if creator.TopStreams[i].URI == "" {
 					continue
 				}
-				creator.TopStreams[i] = GetObject(creator.TopStreams[i].URI)
-				syncCreator()
+				creator.TopStreams[i] = expandObject(creator.TopStreams[i])
+				src.Sync()
 			}
 			for i := 0; i < len(creator.Albums); i++ {
 				if creator.Albums[i].URI == "" {
 					continue
 				}
-				creator.Albums[i] = GetObject(creator.Albums[i].URI)
-				syncCreator()
+				creator.Albums[i] = expandObject(creator.Albums[i])
+				src.Sync()
 			}
 			for i := 0; i < len(creator.Appearances); i++ {
 				if creator.Appearances[i].URI == "" {
 					continue
 				}
-				creator.Appearances[i] = GetObject(creator.Appearances[i].URI)
-				syncCreator()
+				creator.Appearances[i] = expandObject(creator.Appearances[i])
+				src.Sync()
 			}
 			for i := 0; i < len(creator.Singles); i++ {
 				if creator.Singles[i].URI == "" {
 					continue
 				}
-				creator.Singles[i] = GetObject(creator.Singles[i].URI)
-				syncCreator()
+				creator.Singles[i] = expandObject(creator.Singles[i])
+				src.Sync()
 			}
 			for i := 0; i < len(creator.Related); i++ {
 				if creator.Related[i].URI == "" {
 					continue
 				}
-				creator.Related[i] = GetObject(creator.Related[i].URI)
-				syncCreator()
+				creator.Related[i] = expandObject(creator.Related[i])
+				src.Sync()
 			}
 		}
 	case "album":
 		if album := src.Album(); album != nil {
-			syncAlbum := func() {
-				albumJSON, err := json.Marshal(album)
-				if err == nil {
-					src.Object = &json.RawMessage{}
-					src.Object.UnmarshalJSON(albumJSON)
-					src.Sync()
-				}
-			}
 			for i := 0; i < len(album.Creators); i++ {
 				if album.Creators[i].URI == "" {
 					continue
 				}
-				album.Creators[i] = GetObject(album.Creators[i].URI)
-				syncAlbum()
+				album.Creators[i] = expandObject(album.Creators[i])
+				src.Sync()
 			}
 			for i := 0; i < len(album.Discs); i++ {
 				for j := 0; j < len(album.Discs[i].Streams); j++ {
 					if album.Discs[i].Streams[j].URI == "" {
 						continue
 					}
-					album.Discs[i].Streams[j] = GetObject(album.Discs[i].Streams[j].URI)
-					syncAlbum()
+					album.Discs[i].Streams[j] = expandObject(album.Discs[i].Streams[j])
+					src.Sync()
 				}
 			}
 		}
-	case "track", "song", "video", "audio", "stream":
-		if stream := src.Stream(); stream != nil {
-			syncStream := func() {
-				streamJSON, err := json.Marshal(stream)
-				if err == nil {
-					src.Object = &json.RawMessage{}
-					src.Object.UnmarshalJSON(streamJSON)
-					src.Sync()
+	case "playlist":
+		if playlist := src.Playlist(); playlist != nil {
+			for i := 0; i < len(playlist.Streams); i++ {
+				if playlist.Streams[i].URI == "" {
+					continue
 				}
+				playlist.Streams[i] = expandObject(playlist.Streams[i])
+				src.Sync()
 			}
-			stream.Album = GetObject(stream.Album.URI)
-			syncStream()
+		}
+	case "charts":
+		if charts := src.Charts(); charts != nil {
+			for i := 0; i < len(charts.Entries); i++ {
+				charts.Entries[i].Object = expandObject(charts.Entries[i].Object)
+				src.Sync()
+			}
+		}
+	case "track", "song", "video", "audio", "stream":
+		if stream := src.Stream(); stream != nil {
+			stream.Album = expandObject(stream.Album)
+			src.Sync()
 			for i := 0; i < len(stream.Creators); i++ {
 				if stream.Creators[i].URI == "" {
 					continue
 				}
-				stream.Creators[i] = GetObject(stream.Creators[i].URI)
-				syncStream()
+				stream.Creators[i] = expandObject(stream.Creators[i])
+				src.Sync()
 			}
 		}
 	}
@@ -287,22 +365,91 @@ func (src *Object) Expand() {
 		Trace.Println("Failed to expand " + src.URI)
 	}
 	src.Sync()
+	if src.Expanded {
+		//Enrichment only reads this object, so it's safe to serve while a filled in copy is cached
+		go src.Enrich()
+	}
+}
+
+// expandObject returns the full object that the given reference links to, or the reference itself if that fails
+func expandObject(ref *Object) *Object {
+	if ref == nil || ref.URI == "" {
+		return ref
+	}
+	obj := GetObject(ref.URI)
+	if obj == nil || obj.Type == "error" {
+		return ref
+	}
+	if len(ref.Alternatives) == 0 {
+		return obj
+	}
+	//Keep the equivalents a search merged into the reference, which the object itself doesn't know about
+	expanded := *obj
+	expanded.Alternatives = make([]string, 0, len(obj.Alternatives)+len(ref.Alternatives))
+	seen := make(map[string]bool)
+	for _, uri := range append(append([]string{}, obj.Alternatives...), ref.Alternatives...) {
+		if !seen[uri] {
+			seen[uri] = true
+			expanded.Alternatives = append(expanded.Alternatives, uri)
+		}
+	}
+	return &expanded
 }
 
 // GetObjectCached returns a new object from the cache that links to a given URI
 func GetObjectCached(uri string) (obj *Object) {
 	Trace.Println("Retrieving " + uri + " from the cache")
 
-	splitURI := strings.Split(uri, ":")
-	pathURL := "cache/"
-	for i := 0; i < len(splitURI); i++ {
-		if i == len(splitURI)-1 {
-			break
+	parsedURI, err := ParseURI(uri)
+	if err != nil {
+		return nil
+	}
+	pathURL := parsedURI.Path()
+	obj = readObjectCache(uri, pathURL)
+	if obj == nil {
+		return nil
+	}
+
+	//Check if object expired beyond its grace period and was missed during cleanup
+	if obj.Stale && time.Now().After(obj.Expires.Add(service.Cache.Grace(obj.Type))) {
+		Error.Println("Object " + uri + " expired, garbage collecting")
+		os.Remove(pathURL)
+		return nil
+	}
+
+	return obj
+}
+
+// GetObjectOffline returns a new object from the cache that links to a given URI, regardless of when it expired
+func GetObjectOffline(uri string) (obj *Object) {
+	Trace.Println("Retrieving " + uri + " from the cache while offline")
+
+	parsedURI, err := ParseURI(uri)
+	if err != nil {
+		return nil
+	}
+	switch parsedURI.Provider {
+	case "bestmatch":
+		results := SearchCached(parsedURI.ID, searchFilter(parsedURI.Params))
+		for _, matches := range [][]*Object{results.Streams, results.Creators, results.Albums} {
+			if len(matches) > 0 {
+				return matches[0]
+			}
+		}
+		return nil
+	case "charts", "libremedia": //Charts and saved playlists are stored locally, so they're always available
+		return GetObjectLive(uri)
+	case "search":
+		if obj = readObjectCache(uri, parsedURI.Path()); obj != nil {
+			return obj
 		}
-		pathURL += splitURI[i] + "/"
+		return &Object{URI: parsedURI.String(), Type: "search", Provider: "libremedia", Object: SearchCached(parsedURI.ID, searchFilter(parsedURI.Params)), Stale: true}
 	}
-	pathURL += splitURI[len(splitURI)-1] + ".json"
+	return readObjectCache(uri, parsedURI.Path())
+}
 
+// readObjectCache reads and maps a cached object into memory, flagging it as stale if it expired
+func readObjectCache(uri, pathURL string) (obj *Object) {
 	//Check if the object exists
 	info, err := os.Stat(pathURL)
 	if os.IsNotExist(err) {
@@ -322,35 +469,47 @@ func GetObjectCached(uri string) (obj *Object) {
 		return nil
 	}
 
-	//Map the object into memory, or invalidate it to be resynced if that fails
-	obj = &Object{Object: &json.RawMessage{}}
-	err = json.Unmarshal(objData, obj)
+	//Upgrade the object to the current schema, or invalidate it to be resynced if that fails
+	objData, migrated, err := MigrateObjectCache(objData)
+	if errors.Is(err, errSchemaNewer) {
+		Trace.Printf("Object %s was cached by a newer build, skipping it: %v\n", uri, err)
+		return nil
+	}
 	if err != nil {
-		Error.Println("Object " + uri + " failed to map into memory, garbage collecting it instead")
+		Warning.Printf("Object %s can't be migrated, garbage collecting it instead: %v\n", uri, err)
 		os.Remove(pathURL)
 		return nil
 	}
+	if migrated {
+		Trace.Printf("Migrated object %s to schema %d\n", uri, cacheSchema)
+		if err := writeFileAtomic(pathURL, objData, 0777); err != nil {
+			Error.Printf("Failed to write migrated object %s: %v\n", uri, err)
+		}
+	}
 
-	//Check if object expired and was missed during cleanup
-	if obj.Expires != nil && time.Now().After(*obj.Expires) {
-		Error.Println("Object " + uri + " expired, garbage collecting")
+	//Map the object into memory, or invalidate it to be resynced if that fails
+	obj = &Object{}
+	err = json.Unmarshal(objData, obj)
+	if err != nil {
+		Error.Println("Object " + uri + " failed to map into memory, garbage collecting it instead")
 		os.Remove(pathURL)
 		return nil
 	}
 
+	obj.Schema = cacheSchema
+	obj.Stale = obj.IsExpired()
 	return obj
 }
 
-// NewObjError returns an error object
-func NewObjError(msg string) (obj *Object) {
-	obj = &Object{
-		Type: "error",
-		Provider: "libremedia",
-		Object: &json.RawMessage{},
+// NewObjError returns an error object describing the given error
+func NewObjError(err error) (obj *Object) {
+	objErr, ok := err.(*ObjectError)
+	if !ok {
+		objErr = WrapError(err, "", "libremedia")
 	}
-	errJSON, err := json.Marshal(&exporterr{Error: msg})
-	if err == nil {
-		obj.Object.UnmarshalJSON(errJSON)
+	return &Object{
+		Type:     "error",
+		Provider: "libremedia",
+		Object:   objErr,
 	}
-	return obj
 }
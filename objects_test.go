@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestObjectUnmarshalJSON(t *testing.T) {
+	creator := &Object{URI: "tidal:artist:1", Type: "creator", Provider: "tidal", Object: &ObjectCreator{Name: "Daft Punk", URI: "tidal:artist:1"}}
+	album := &Object{URI: "tidal:album:2", Type: "album", Provider: "tidal", Object: &ObjectAlbum{Name: "Discovery", UPC: "724384960650", Creators: []*Object{{URI: creator.URI, Type: "creator", Provider: "tidal", Object: &ObjectCreator{Name: "Daft Punk"}}}}}
+	tests := []*Object{
+		creator,
+		album,
+		{URI: "tidal:track:3", Type: "stream", Provider: "tidal", Object: &ObjectStream{
+			Name:     "One More Time",
+			Duration: 320,
+			Formats:  []*ObjectFormat{{ID: 0, Name: "LOSSLESS", Codec: "flac", BitRate: 1411000, SampleRate: 44100}},
+			Creators: []*Object{creator},
+			Album:    album,
+			Transcript: &ObjectTranscript{TimeSynced: true, SyncType: TranscriptWordSynced, Lines: []*ObjectTranscriptLine{
+				{StartTimeMs: 1000, EndTimeMs: 2000, Text: "One more time", Segments: []*ObjectTranscriptSegment{{StartTimeMs: 1000, EndTimeMs: 1500, Text: "One "}, {StartTimeMs: 1500, EndTimeMs: 2000, Text: "more time"}}},
+			}},
+		}},
+		{URI: "search:daft punk", Type: "search", Provider: "libremedia", Object: &ObjectSearchResults{Query: "daft punk", Creators: []*Object{creator}, Albums: []*Object{album}}},
+		{URI: "tidal:album:2:artwork", Type: "artwork", Provider: "tidal", Object: &ObjectArtwork{Width: 1280, Height: 1280, URL: "https://example.com/2.jpg", Type: "jpg"}},
+		{URI: "tidal:track:3:transcript", Type: "transcript", Provider: "tidal", Object: &ObjectTranscript{Language: "en", Lines: []*ObjectTranscriptLine{{Text: "One more time"}}}},
+		{URI: "charts:streams?window=week", Type: "charts", Provider: "libremedia", Object: &ObjectCharts{Chart: "streams", Window: "week", Entries: []*ObjectChartEntry{{Count: 3, Object: creator}}}},
+		{URI: "libremedia:playlist:abc", Type: "playlist", Provider: "libremedia", Object: &ObjectPlaylist{Name: "Mix", Streams: []*Object{creator}}},
+		{Type: "error", Provider: "libremedia", Object: NewError(ErrNotFound, "tidal", "no such track")},
+		{URI: "tidal:track:4", Type: "track", Provider: "tidal", Object: &ObjectStream{Name: "Aerodynamic"}},
+		{URI: "tidal:artist:5", Type: "artist", Provider: "tidal", Object: &ObjectCreator{Name: "Thomas Bangalter"}},
+		{URI: "tidal:track:6", Type: "stream", Provider: "tidal", Alternatives: []string{"spotify:track:6"}, Sources: map[string]string{"description": "spotify"}},
+	}
+	for _, want := range tests {
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Errorf("failed to encode %s: %v", want.URI, err)
+			continue
+		}
+		got := &Object{}
+		if err := json.Unmarshal(data, got); err != nil {
+			t.Errorf("failed to decode %s: %v", data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s didn't round trip:\n got %s\nwant %s", want.URI, mustJSON(t, got), data)
+		}
+		if reflect.TypeOf(got.Object) != reflect.TypeOf(want.Object) {
+			t.Errorf("%s decoded into %T, want %T", want.URI, got.Object, want.Object)
+		}
+	}
+}
+
+func TestObjectUnmarshalJSONRaw(t *testing.T) {
+	data := []byte(`{"uri":"plugin:thing:1","type":"thing","provider":"plugin","object":{"b":[1,2],"a":"x"}}`)
+	obj := &Object{}
+	if err := json.Unmarshal(data, obj); err != nil {
+		t.Fatalf("failed to decode an unknown type: %v", err)
+	}
+	raw, ok := obj.Object.(objectRaw)
+	if !ok {
+		t.Fatalf("unknown type decoded into %T, want objectRaw", obj.Object)
+	}
+	if string(raw) != `{"b":[1,2],"a":"x"}` {
+		t.Errorf("unknown payload = %s, want it kept as-is", raw)
+	}
+	if got := mustJSON(t, obj); got != string(data) {
+		t.Errorf("unknown type re-encoded as %s, want %s", got, data)
+	}
+}
+
+func TestObjectUnmarshalJSONEmpty(t *testing.T) {
+	for _, data := range []string{
+		`{"uri":"tidal:track:1","type":"stream"}`,
+		`{"uri":"tidal:track:1","type":"stream","object":null}`,
+	} {
+		//Decoding into a reused object must not keep its old payload
+		obj := &Object{Object: &ObjectStream{Name: "old"}}
+		if err := json.Unmarshal([]byte(data), obj); err != nil {
+			t.Errorf("failed to decode %s: %v", data, err)
+			continue
+		}
+		if obj.Object != nil {
+			t.Errorf("%s decoded with payload %#v, want none", data, obj.Object)
+		}
+	}
+
+	obj := &Object{}
+	if err := json.Unmarshal([]byte(`{"type":"stream","object":{"name":1}}`), obj); err == nil {
+		t.Errorf("decoded a stream with a mistyped payload, want error")
+	}
+}
+
+func mustJSON(t testing.TB, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to encode %#v: %v", v, err)
+	}
+	return string(data)
+}
+
+// rawObject is how objects were held before their payloads were typed, kept to benchmark against
+type rawObject struct {
+	URI       string           `json:"uri,omitempty"`
+	Type      string           `json:"type,omitempty"`
+	Provider  string           `json:"provider,omitempty"`
+	Expires   *time.Time       `json:"expires,omitempty"`
+	LastMod   *time.Time       `json:"lastMod,omitempty"`
+	Object    *json.RawMessage `json:"object,omitempty"`
+	Expanding bool             `json:"expanding,omitempty"`
+	Expanded  bool             `json:"expanded,omitempty"`
+	Stale     bool             `json:"stale,omitempty"`
+}
+
+// newRawObject returns the raw form of a typed object
+func newRawObject(tb testing.TB, obj *Object) *rawObject {
+	raw := &rawObject{}
+	if err := json.Unmarshal([]byte(mustJSON(tb, obj)), raw); err != nil {
+		tb.Fatalf("failed to decode %s as a raw object: %v", obj.URI, err)
+	}
+	return raw
+}
+
+// payload decodes the raw payload into v, as every accessor did before payloads were typed
+func (obj *rawObject) payload(v interface{}) bool {
+	if obj.Object == nil {
+		return false
+	}
+	objJSON, err := obj.Object.MarshalJSON()
+	return err == nil && json.Unmarshal(objJSON, v) == nil
+}
+
+func (obj *rawObject) Stream() *ObjectStream {
+	ret := &ObjectStream{}
+	if obj.Type != "stream" || !obj.payload(ret) {
+		return nil
+	}
+	return ret
+}
+
+func (obj *rawObject) Album() *ObjectAlbum {
+	ret := &ObjectAlbum{}
+	if obj.Type != "album" || !obj.payload(ret) {
+		return nil
+	}
+	return ret
+}
+
+func (obj *rawObject) Creator() *ObjectCreator {
+	ret := &ObjectCreator{}
+	if obj.Type != "creator" || !obj.payload(ret) {
+		return nil
+	}
+	return ret
+}
+
+func (obj *rawObject) SearchResults() *ObjectSearchResults {
+	ret := &ObjectSearchResults{}
+	if obj.Type != "search" || !obj.payload(ret) {
+		return nil
+	}
+	return ret
+}
+
+// sync writes the raw object to the cache the same way Sync does
+func (obj *rawObject) sync() {
+	uri, err := ParseURI(obj.URI)
+	if err != nil {
+		return
+	}
+	objData, err := json.Marshal(obj)
+	if err != nil {
+		return
+	}
+	os.MkdirAll(filepath.Dir(uri.Path()), 0777)
+	writeFileAtomic(uri.Path(), objData, 0777)
+}
+
+// expand fills in the streams of a raw album the way Expand did before payloads were typed, re-encoding the album after each one
+func (obj *rawObject) expand() {
+	obj.Expanding = true
+	obj.sync()
+	album := obj.Album()
+	for i := 0; i < len(album.Discs); i++ {
+		for j := 0; j < len(album.Discs[i].Streams); j++ {
+			album.Discs[i].Streams[j] = expandObject(album.Discs[i].Streams[j])
+			albumJSON, err := json.Marshal(album)
+			if err == nil {
+				raw := json.RawMessage(albumJSON)
+				obj.Object = &raw
+				obj.sync()
+			}
+		}
+	}
+	obj.Expanding = false
+	obj.Expanded = true
+	obj.sync()
+}
+
+// benchmarkObjects returns a set of nested objects like the ones providers return
+func benchmarkObjects() (stream, album, creator, search *Object) {
+	creator = &Object{URI: "tidal:artist:1", Type: "creator", Provider: "tidal", Object: &ObjectCreator{Name: "Daft Punk", Description: "French electronic duo", Genres: []string{"house", "electronic"}}}
+	album = &Object{URI: "tidal:album:1", Type: "album", Provider: "tidal", Object: &ObjectAlbum{Name: "Discovery", UPC: "724384960650", Creators: []*Object{{URI: creator.URI, Type: "creator", Provider: "tidal", Object: &ObjectCreator{Name: "Daft Punk"}}}}}
+	disc := &ObjectDisc{Disc: 1}
+	streams := make([]*Object, 0)
+	for i := 1; i <= 14; i++ {
+		track := testStream("tidal", strconv.Itoa(i), "Track "+strconv.Itoa(i), "Daft Punk", "FRZ0900000"+strconv.Itoa(i), 240)
+		track.Stream().Album = &Object{URI: album.URI, Type: "album", Provider: "tidal", Object: &ObjectAlbum{Name: "Discovery"}}
+		track.Stream().Formats = []*ObjectFormat{
+			{ID: 0, Name: "HI_RES", Codec: "flac", BitRate: 9216000, SampleRate: 96000},
+			{ID: 1, Name: "LOSSLESS", Codec: "flac", BitRate: 1411000, SampleRate: 44100},
+			{ID: 2, Name: "HIGH", Codec: "aac", BitRate: 320000, SampleRate: 44100},
+		}
+		disc.Streams = append(disc.Streams, track)
+		streams = append(streams, track)
+	}
+	album.Album().Discs = []*ObjectDisc{disc}
+	creator.Creator().Albums = []*Object{album}
+	creator.Creator().TopStreams = streams[:10]
+	search = &Object{URI: "search:daft punk", Type: "search", Provider: "libremedia", Object: &ObjectSearchResults{Query: "daft punk", Streams: streams, Albums: []*Object{album}, Creators: []*Object{creator}}}
+	return streams[0], album, creator, search
+}
+
+func BenchmarkObjectAccessors(b *testing.B) {
+	stream, album, creator, search := benchmarkObjects()
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if stream.Stream() == nil || album.Album() == nil || creator.Creator() == nil || search.SearchResults() == nil {
+				b.Fatal("accessor returned nil")
+			}
+		}
+	})
+	rawStream, rawAlbum, rawCreator, rawSearch := newRawObject(b, stream), newRawObject(b, album), newRawObject(b, creator), newRawObject(b, search)
+	b.Run("raw", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if rawStream.Stream() == nil || rawAlbum.Album() == nil || rawCreator.Creator() == nil || rawSearch.SearchResults() == nil {
+				b.Fatal("accessor returned nil")
+			}
+		}
+	})
+}
+
+func BenchmarkObjectExpand(b *testing.B) {
+	dir, err := ioutil.TempDir("", "libremedia")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	service.Offline = true
+	defer func() { service.Offline = false }()
+
+	//Expanding an album reads each of its streams from the cache
+	_, album, _, _ := benchmarkObjects()
+	for _, stream := range album.Album().Discs[0].Streams {
+		stream.Sync()
+	}
+	albumJSON := mustJSON(b, album)
+
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			obj := &Object{}
+			json.Unmarshal([]byte(albumJSON), obj)
+			b.StartTimer()
+			obj.Expand()
+		}
+	})
+	b.Run("raw", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			obj := &rawObject{}
+			json.Unmarshal([]byte(albumJSON), obj)
+			b.StartTimer()
+			obj.expand()
+		}
+	})
+}
+
+func BenchmarkObjectCache(b *testing.B) {
+	stream, album, creator, search := benchmarkObjects()
+	objs := []*Object{stream, album, creator, search}
+
+	//A cache round trip encodes an object, decodes it again and reads its payload
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, obj := range objs {
+				data, err := json.Marshal(obj)
+				if err != nil {
+					b.Fatal(err)
+				}
+				decoded := &Object{}
+				if err := json.Unmarshal(data, decoded); err != nil {
+					b.Fatal(err)
+				}
+				if decoded.Stream() == nil && decoded.Album() == nil && decoded.Creator() == nil && decoded.SearchResults() == nil {
+					b.Fatal("decoded without a payload")
+				}
+			}
+		}
+	})
+	raws := make([]*rawObject, 0, len(objs))
+	for _, obj := range objs {
+		raws = append(raws, newRawObject(b, obj))
+	}
+	b.Run("raw", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, obj := range raws {
+				data, err := json.Marshal(obj)
+				if err != nil {
+					b.Fatal(err)
+				}
+				decoded := &rawObject{}
+				if err := json.Unmarshal(data, decoded); err != nil {
+					b.Fatal(err)
+				}
+				if decoded.Stream() == nil && decoded.Album() == nil && decoded.Creator() == nil && decoded.SearchResults() == nil {
+					b.Fatal("decoded without a payload")
+				}
+			}
+		}
+	})
+}
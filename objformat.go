@@ -28,5 +28,8 @@ func (obj *ObjectFormat) JSON() []byte {
 }
 
 func (obj *ObjectFormat) GenerateURL(uri string) {
+	if parsedURI, err := ParseURI(uri); err == nil {
+		uri = parsedURI.Base().String()
+	}
 	obj.URL = service.BaseURL + "v1/stream/" + uri + "?format=" + strconv.Itoa(obj.ID)
 }
\ No newline at end of file
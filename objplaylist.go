@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// ObjectPlaylist holds metadata about a playlist
+type ObjectPlaylist struct {
+	Provider    string           `json:"provider,omitempty"`
+	URI         string           `json:"uri,omitempty"`         //The URI that refers to this playlist object
+	Name        string           `json:"name,omitempty"`        //The name of this playlist
+	Description string           `json:"description,omitempty"` //The description of this playlist
+	Artworks    []*ObjectArtwork `json:"artworks,omitempty"`    //The artworks for this playlist
+	Creators    []*Object        `json:"creators,omitempty"`    //The creators of this playlist
+	Streams     []*Object        `json:"streams,omitempty"`     //The streams in this playlist, in order
+}
+
+func (obj *ObjectPlaylist) JSON() []byte {
+	objJSON, err := json.Marshal(obj)
+	if err != nil {
+		return nil
+	}
+	return objJSON
+}
+
+func (obj *ObjectPlaylist) IsEmpty() bool {
+	return len(obj.Streams) == 0
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// ObjectProvider holds metadata about an upstream provider and what it supports
+type ObjectProvider struct {
+	Provider      string          `json:"provider,omitempty"`    //The provider used in URIs, ex: tidal
+	Name          string          `json:"name,omitempty"`        //The display name of this provider, ex: TIDAL
+	Icon          string          `json:"icon,omitempty"`        //The path to this provider's icon
+	Types         []string        `json:"types,omitempty"`       //The object types this provider serves, ex: creator, album, stream
+	Formats       []*ObjectFormat `json:"formats,omitempty"`     //The format templates this provider streams, ordered from best to worst
+	Transcribes   bool            `json:"transcribes,omitempty"` //Whether or not this provider can fill in transcripts
+	Authenticated bool            `json:"authenticated"`         //Whether or not this provider holds a valid session
+	Healthy       bool            `json:"healthy"`               //Whether or not this provider passed its last health check
+	Error         string          `json:"error,omitempty"`       //Why this provider failed its last health check
+}
+
+func (obj *ObjectProvider) JSON() []byte {
+	objJSON, err := json.Marshal(obj)
+	if err != nil {
+		return nil
+	}
+	return objJSON
+}
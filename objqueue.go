@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+)
+
+// ObjectQueue holds the streams a user has queued, in the order they were queued
+type ObjectQueue struct {
+	Streams []*Object `json:"streams"`         //The queued streams in their original order
+	Shuffle bool      `json:"shuffle"`         //Whether or not the shuffled order is in use
+	Order   []int     `json:"order,omitempty"` //The shuffled order as indexes into streams, only while shuffle is enabled
+}
+
+func (obj *ObjectQueue) JSON() []byte {
+	objJSON, err := json.Marshal(obj)
+	if err != nil {
+		return nil
+	}
+	return objJSON
+}
+
+// View returns the queued streams in the order they'll play, shuffled if shuffle is enabled
+func (obj *ObjectQueue) View() []*Object {
+	if !obj.Shuffle {
+		return obj.Streams
+	}
+	view := make([]*Object, 0, len(obj.Order))
+	for i := 0; i < len(obj.Order); i++ {
+		view = append(view, obj.Streams[obj.Order[i]])
+	}
+	return view
+}
+
+// Insert queues streams at an index of the original order, appending them to the shuffled order
+func (obj *ObjectQueue) Insert(index int, streams ...*Object) error {
+	if index < 0 || index > len(obj.Streams) {
+		return NewError(ErrBadURI, "", "queue: no index %d", index)
+	}
+	inserted := make([]*Object, 0, len(obj.Streams)+len(streams))
+	inserted = append(inserted, obj.Streams[:index]...)
+	inserted = append(inserted, streams...)
+	inserted = append(inserted, obj.Streams[index:]...)
+	obj.Streams = inserted
+	if obj.Shuffle {
+		for i := 0; i < len(obj.Order); i++ {
+			if obj.Order[i] >= index {
+				obj.Order[i] += len(streams)
+			}
+		}
+		for i := 0; i < len(streams); i++ {
+			obj.Order = append(obj.Order, index+i)
+		}
+	}
+	return nil
+}
+
+// Append queues streams at the end of both orders
+func (obj *ObjectQueue) Append(streams ...*Object) {
+	obj.Insert(len(obj.Streams), streams...)
+}
+
+// Remove unqueues the stream at an index of the original order
+func (obj *ObjectQueue) Remove(index int) error {
+	if index < 0 || index >= len(obj.Streams) {
+		return NewError(ErrBadURI, "", "queue: no index %d", index)
+	}
+	obj.Streams = append(obj.Streams[:index], obj.Streams[index+1:]...)
+	if obj.Shuffle {
+		order := make([]int, 0, len(obj.Order))
+		for i := 0; i < len(obj.Order); i++ {
+			switch {
+			case obj.Order[i] == index:
+			case obj.Order[i] > index:
+				order = append(order, obj.Order[i]-1)
+			default:
+				order = append(order, obj.Order[i])
+			}
+		}
+		obj.Order = order
+	}
+	return nil
+}
+
+// Move moves the stream at one index of the original order to another, leaving the shuffled order as it was
+func (obj *ObjectQueue) Move(from, to int) error {
+	if from < 0 || from >= len(obj.Streams) {
+		return NewError(ErrBadURI, "", "queue: no index %d", from)
+	}
+	if to < 0 || to >= len(obj.Streams) {
+		return NewError(ErrBadURI, "", "queue: no index %d", to)
+	}
+	stream := obj.Streams[from]
+	obj.Streams = append(obj.Streams[:from], obj.Streams[from+1:]...)
+	obj.Streams = append(obj.Streams[:to], append([]*Object{stream}, obj.Streams[to:]...)...)
+	if obj.Shuffle {
+		for i := 0; i < len(obj.Order); i++ {
+			switch index := obj.Order[i]; {
+			case index == from:
+				obj.Order[i] = to
+			case from < to && index > from && index <= to:
+				obj.Order[i]--
+			case from > to && index >= to && index < from:
+				obj.Order[i]++
+			}
+		}
+	}
+	return nil
+}
+
+// Clear unqueues every stream
+func (obj *ObjectQueue) Clear() {
+	obj.Streams = make([]*Object, 0)
+	if obj.Shuffle {
+		obj.Order = make([]int, 0)
+	}
+}
+
+// SetShuffle enables shuffle with a newly generated shuffled order, or disables it to return to the original order
+func (obj *ObjectQueue) SetShuffle(shuffle bool) {
+	obj.Shuffle = shuffle
+	obj.Order = nil
+	if shuffle {
+		obj.Order = rand.Perm(len(obj.Streams))
+	}
+}
@@ -6,11 +6,12 @@ import (
 
 // ObjectSearchResults holds the results for a given search query
 type ObjectSearchResults struct {
-	Query     string    `json:"query,omitempty"`     //The query that generated these results
-	Streams   []*Object `json:"streams,omitempty"`   //The stream results for this query
-	Creators  []*Object `json:"creators,omitempty"`  //The creator results for this query
-	Albums    []*Object `json:"albums,omitempty"`    //The album results for this query
-	Provider string `json:"provider,omitempty"`
+	Query    string         `json:"query,omitempty"`    //The query that generated these results
+	Streams  []*Object      `json:"streams,omitempty"`  //The stream results for this query
+	Creators []*Object      `json:"creators,omitempty"` //The creator results for this query
+	Albums   []*Object      `json:"albums,omitempty"`   //The album results for this query
+	Provider string         `json:"provider,omitempty"`
+	Errors   []*ObjectError `json:"errors,omitempty"` //Why some providers didn't return results, if any
 }
 
 func (obj *ObjectSearchResults) JSON() []byte {
@@ -23,4 +24,4 @@ func (obj *ObjectSearchResults) JSON() []byte {
 
 func (obj *ObjectSearchResults) IsEmpty() bool {
 	return len(obj.Streams) == 0 && len(obj.Creators) == 0 && len(obj.Albums) == 0
-}
\ No newline at end of file
+}
@@ -2,9 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"strings"
-
-	"github.com/rhnvrm/lyric-api-go"
 )
 
 // ObjectStream holds metadata about a stream and the available formats to stream
@@ -21,6 +18,8 @@ type ObjectStream struct {
 	Creators   []*Object         `json:"creators,omitempty"`   //The creators of this file
 	Album      *Object           `json:"album,omitempty"`      //The album that holds this file
 	DateTime   string            `json:"datetime,omitempty"`   //The release date of this file
+	ISRC       string            `json:"isrc,omitempty"`       //The International Standard Recording Code of this file
+	Popularity int               `json:"popularity,omitempty"` //How popular this file is on its provider, from 0 to 100
 	Provider   string            `json:"provider,omitempty"`
 	URI        string            `json:"uri,omitempty"`     //The URI that refers to this stream object
 	ID         string            `json:"id,omitempty"`      //The ID that refers to this stream object
@@ -35,7 +34,7 @@ func (obj *ObjectStream) JSON() []byte {
 	return objJSON
 }
 
-func (obj *ObjectStream) FileName() string {
+func (obj *ObjectStream) FileName(format int) string {
 	creatorName := obj.Creators[0].Creator().Name
 	album := obj.Album.Album()
 	albumName := album.Name
@@ -46,7 +45,10 @@ func (obj *ObjectStream) FileName() string {
 	if albumDate != "" {
 		fileName += " " + albumDate
 	}
-	fileName += " - " + trackName + "." + obj.Formats[0].Format
+	fileName += " - " + trackName
+	if objFormat := obj.GetFormat(format); objFormat != nil {
+		fileName += "." + objFormat.Format
+	}
 	return fileName
 }
 
@@ -63,39 +65,7 @@ func (obj *ObjectStream) Transcribe() {
 	if obj.Transcript != nil && len(obj.Transcript.Lines) > 0 {
 		return //You should expire the object if you want to resync it
 	}
-
-	//Try the source of the object first
-	//TODO: Check all providers when providers can be an array
-	if handler, exists := handlers[obj.Provider]; exists {
-		if err := handler.Transcribe(obj); err == nil {
-			return
-		}
-	}
-
-	//Make sure we at least know the creator and stream names first
-	if len(obj.Creators) > 0 && obj.Name != "" {
-		l := lyrics.New()
-
-		//We want the first creator that matches a result
-		for i := 0; i < len(obj.Creators); i++ {
-			creator := obj.Creators[i].Creator()
-			if creator != nil {
-				transcript, err := l.Search(creator.Name, obj.Name)
-				if err == nil {
-					obj.Transcript = &ObjectTranscript{
-						Provider:         "libremedia",
-						ProviderLyricsID: obj.ID,
-						ProviderTrackID:  obj.ID,
-						Lines:            make([]*ObjectTranscriptLine, 0),
-					}
-					lines := strings.Split(transcript, "\n")
-					for j := 0; j < len(lines); j++ {
-						line := lines[j]
-						obj.Transcript.Lines = append(obj.Transcript.Lines, &ObjectTranscriptLine{Text: line})
-					}
-					return
-				}
-			}
-		}
+	if transcript := service.Transcribe(obj); transcript != nil {
+		obj.Transcript = transcript
 	}
 }
@@ -1,15 +1,48 @@
 package main
 
+import (
+	"encoding/json"
+)
+
+// Sync types of a transcript
+const (
+	TranscriptUnsynced       = "unsynced" //Lines have no timing
+	TranscriptLineSynced     = "line"     //Each line has a start time
+	TranscriptWordSynced     = "word"     //Each line is split into timed words
+	TranscriptSyllableSynced = "syllable" //Each line is split into timed syllables
+)
+
 type ObjectTranscript struct {
-	RightToLeft bool `json:"rightToLeft,omitempty"`
-	Provider string `json:"provider,omitempty"`
-	ProviderLyricsID string `json:"providerLyricsId,omitempty"`
-	ProviderTrackID string `json:"providerTrackId,omitempty"` //SyncLyricsURI
-	TimeSynced bool `json:"timeSynced,omitempty"`
-	Lines []*ObjectTranscriptLine `json:"lines,omitempty"`
+	RightToLeft      bool                    `json:"rightToLeft,omitempty"`
+	Language         string                  `json:"language,omitempty"` //The language of the lines, ex: en
+	Provider         string                  `json:"provider,omitempty"`
+	ProviderName     string                  `json:"providerName,omitempty"` //The display name of the provider, ex: Musixmatch
+	ProviderLyricsID string                  `json:"providerLyricsId,omitempty"`
+	ProviderTrackID  string                  `json:"providerTrackId,omitempty"` //SyncLyricsURI
+	TimeSynced       bool                    `json:"timeSynced,omitempty"`
+	SyncType         string                  `json:"syncType,omitempty"` //How finely the lines are timed, ex: unsynced, line, word, syllable
+	Lines            []*ObjectTranscriptLine `json:"lines,omitempty"`
+	Extra            map[string]string       `json:"extra,omitempty"` //Provider-specific details, ex: colors to display the lines with
 }
 
 type ObjectTranscriptLine struct {
-	StartTimeMs int `json:"startTimeMs,omitempty"`
-	Text string `json:"text,omitempty"`
-}
\ No newline at end of file
+	StartTimeMs int                        `json:"startTimeMs,omitempty"`
+	EndTimeMs   int                        `json:"endTimeMs,omitempty"` //When the line ends, if the provider says so
+	Text        string                     `json:"text,omitempty"`
+	Segments    []*ObjectTranscriptSegment `json:"segments,omitempty"` //The timed words or syllables of the line, if the provider has them
+}
+
+// ObjectTranscriptSegment holds a timed word or syllable of a transcript line, including any whitespace that follows it so the segments of a line join back into its text
+type ObjectTranscriptSegment struct {
+	StartTimeMs int    `json:"startTimeMs"`
+	EndTimeMs   int    `json:"endTimeMs,omitempty"`
+	Text        string `json:"text"`
+}
+
+func (obj *ObjectTranscript) JSON() []byte {
+	objJSON, err := json.Marshal(obj)
+	if err != nil {
+		return nil
+	}
+	return objJSON
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	playerPath    = "players/" //Where each user's player is stored
+	playerRestart = 3          //How many seconds into a stream going back restarts it instead of playing the previous one
+)
+
+// Repeat modes of a player
+const (
+	PlayerRepeatOff = "off" //Stop after the last stream in the queue
+	PlayerRepeatAll = "all" //Start the queue over after the last stream
+	PlayerRepeatOne = "one" //Play the same stream over and over
+)
+
+var (
+	players     = make(map[string]*PlayerState) //Players loaded into memory by user ID
+	playersLock sync.Mutex
+)
+
+// PlayerState holds the authoritative playback state of a user, shared by every device they control it from
+type PlayerState struct {
+	NowPlaying int       `json:"nowPlaying"`       //The index of the playing stream within the queue's view, or -1 if nothing is playing
+	URI        string    `json:"uri,omitempty"`    //The URI of the playing stream, used to find it again when the queue changes
+	Stream     *Object   `json:"stream,omitempty"` //The playing stream as it's listed in the queue
+	Position   float64   `json:"position"`         //The playback position in seconds as of when it was last updated
+	Paused     bool      `json:"paused"`           //Whether or not playback is paused
+	Volume     int       `json:"volume"`           //The playback volume, from 0 to 100
+	Repeat     string    `json:"repeat"`           //How the queue repeats, ex: off, all, one
+	Shuffle    bool      `json:"shuffle"`          //Whether or not the queue is shuffled
+	Updated    time.Time `json:"updated"`          //When the playback position was last updated
+}
+
+// Player runs a command against a user's player while holding the player lock, saving the player afterwards if the command succeeded, and returns a copy of the player
+func Player(user *ServiceUser, command func(player *PlayerState, view []*Object) error) (*PlayerState, error) {
+	playersLock.Lock()
+	defer playersLock.Unlock()
+
+	player, exists := players[user.ID]
+	if !exists {
+		player = &PlayerState{NowPlaying: -1, Paused: true, Volume: 100, Repeat: PlayerRepeatOff, Updated: time.Now()}
+		playerData, err := ioutil.ReadFile(playerPath + user.ID + ".json")
+		if err == nil {
+			if err := json.Unmarshal(playerData, player); err != nil {
+				Warning.Printf("Dropping unreadable player of %s: %v\n", user.ID, err)
+				player = &PlayerState{NowPlaying: -1, Paused: true, Volume: 100, Repeat: PlayerRepeatOff, Updated: time.Now()}
+			}
+		}
+		players[user.ID] = player
+	}
+
+	queue, err := Queue(user, nil)
+	if err != nil {
+		return nil, err
+	}
+	view := queue.View()
+	player.sync(view)
+	if command != nil {
+		if err := command(player, view); err != nil {
+			return nil, err
+		}
+		player.sync(view)
+		playerData, err := json.Marshal(player)
+		if err != nil {
+			return nil, WrapError(err, "libremedia", "player: couldn't encode player of %s", user.ID)
+		}
+		os.MkdirAll(playerPath, 0777)
+		if err := writeFileAtomic(playerPath+user.ID+".json", playerData, 0644); err != nil {
+			return nil, WrapError(err, "libremedia", "player: couldn't save player of %s", user.ID)
+		}
+	}
+
+	//Return a copy with the current position, so it can be served after the lock is released
+	snapshot := *player
+	snapshot.Position = player.position()
+	snapshot.Updated = time.Now()
+	snapshot.Shuffle = queue.Shuffle
+	snapshot.Stream = nil
+	if player.NowPlaying >= 0 {
+		snapshot.Stream = view[player.NowPlaying]
+	}
+	return &snapshot, nil
+}
+
+// Play starts playing the stream at an index of the queue's view from the beginning
+func (p *PlayerState) Play(index int, view []*Object) error {
+	if index < 0 || index >= len(view) {
+		return NewError(ErrBadURI, "", "player: no index %d in the queue", index)
+	}
+	p.NowPlaying = index
+	p.URI = view[index].URI
+	p.Position = 0
+	p.Paused = false
+	p.Updated = time.Now()
+	return nil
+}
+
+// Pause pauses playback at the current position
+func (p *PlayerState) Pause() {
+	p.Position = p.position()
+	p.Paused = true
+	p.Updated = time.Now()
+}
+
+// Resume continues playback from the current position, or starts the queue if nothing is playing
+func (p *PlayerState) Resume(view []*Object) error {
+	if p.NowPlaying < 0 {
+		return p.Play(0, view)
+	}
+	p.Updated = time.Now()
+	p.Paused = false
+	return nil
+}
+
+// Seek moves playback to a position in seconds
+func (p *PlayerState) Seek(position float64) error {
+	if position < 0 {
+		return NewError(ErrBadURI, "", "player: can't seek to %v", position)
+	}
+	p.Position = position
+	p.Updated = time.Now()
+	return nil
+}
+
+// Next skips to the next stream in the queue's view, even when repeating one stream
+func (p *PlayerState) Next(view []*Object) {
+	next := p.NowPlaying + 1
+	if next >= len(view) {
+		if p.Repeat != PlayerRepeatAll || len(view) == 0 {
+			p.stop()
+			return
+		}
+		next = 0
+	}
+	p.Play(next, view)
+}
+
+// Prev restarts the playing stream, or goes back to the previous one if it only just started
+func (p *PlayerState) Prev(view []*Object) {
+	if p.NowPlaying < 0 {
+		return
+	}
+	if p.position() > playerRestart || p.NowPlaying == 0 {
+		p.Play(p.NowPlaying, view)
+		return
+	}
+	p.Play(p.NowPlaying-1, view)
+}
+
+// SetVolume sets the playback volume, from 0 to 100
+func (p *PlayerState) SetVolume(volume int) error {
+	if volume < 0 || volume > 100 {
+		return NewError(ErrBadURI, "", "player: volume must be from 0 to 100")
+	}
+	p.Volume = volume
+	return nil
+}
+
+// SetRepeat sets how the queue repeats
+func (p *PlayerState) SetRepeat(repeat string) error {
+	switch repeat {
+	case PlayerRepeatOff, PlayerRepeatAll, PlayerRepeatOne:
+		p.Repeat = repeat
+		return nil
+	}
+	return NewError(ErrBadURI, "", "player: no repeat mode %s, try off, all or one", repeat)
+}
+
+// stop stops playback
+func (p *PlayerState) stop() {
+	p.NowPlaying = -1
+	p.URI = ""
+	p.Position = 0
+	p.Paused = true
+	p.Updated = time.Now()
+}
+
+// position returns the current playback position in seconds
+func (p *PlayerState) position() float64 {
+	if p.Paused || p.NowPlaying < 0 {
+		return p.Position
+	}
+	return p.Position + time.Since(p.Updated).Seconds()
+}
+
+// sync finds the playing stream again after the queue changed, and moves past any streams that ended since the player was last checked
+func (p *PlayerState) sync(view []*Object) {
+	if p.NowPlaying >= 0 && (p.NowPlaying >= len(view) || view[p.NowPlaying].URI != p.URI) {
+		found := -1
+		for i := 0; i < len(view); i++ {
+			if view[i].URI == p.URI {
+				found = i
+				break
+			}
+		}
+		if found == -1 {
+			p.stop()
+			return
+		}
+		p.NowPlaying = found
+	}
+
+	for !p.Paused && p.NowPlaying >= 0 {
+		stream := view[p.NowPlaying].Stream()
+		if stream == nil || stream.Duration <= 0 {
+			return //Without a duration, the stream plays until a device moves on
+		}
+		duration := float64(stream.Duration)
+		if p.position() < duration {
+			return
+		}
+		ended := p.Updated.Add(time.Duration((duration - p.Position) * float64(time.Second)))
+		if p.Repeat == PlayerRepeatOne {
+			p.Play(p.NowPlaying, view)
+		} else {
+			p.Next(view)
+		}
+		if !p.Paused {
+			p.Updated = ended
+		}
+	}
+}
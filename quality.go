@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Codecs that never lose quality, regardless of their container
+var losslessCodecs = map[string]bool{
+	"flac": true,
+	"alac": true,
+	"pcm":  true,
+	"wav":  true,
+}
+
+// QualityPolicy holds constraints on which formats may be streamed, either requested by a client or capped by an admin
+type QualityPolicy struct {
+	Codec         string `json:"codec,omitempty"`         //Only formats with this codec, ex: flac, vorbis
+	MaxBitRate    int32  `json:"maxBitrate,omitempty"`    //Only formats at or below this bitrate, ex: 320000
+	MaxSampleRate int32  `json:"maxSampleRate,omitempty"` //Only formats at or below this sample rate, ex: 44100
+	Lossless      bool   `json:"lossless,omitempty"`      //Only lossless formats
+}
+
+// QualityConfig holds the quality caps set by an admin
+type QualityConfig struct {
+	Global *QualityPolicy            `json:"global"` //Caps every user
+	Users  map[string]*QualityPolicy `json:"users"`  //Caps the user holding each access key, on top of the global caps
+}
+
+// Allows returns true if a format satisfies every constraint of the policy
+func (p *QualityPolicy) Allows(format *ObjectFormat) bool {
+	if p == nil {
+		return true
+	}
+	if p.Codec != "" && !strings.EqualFold(p.Codec, format.Codec) {
+		return false
+	}
+	if p.MaxBitRate > 0 && format.BitRate > p.MaxBitRate {
+		return false
+	}
+	if p.MaxSampleRate > 0 && format.SampleRate > p.MaxSampleRate {
+		return false
+	}
+	if p.Lossless && !losslessCodecs[strings.ToLower(format.Codec)] {
+		return false
+	}
+	return true
+}
+
+// Merge returns a policy that satisfies both policies, or an error if they ask for different codecs
+func (p *QualityPolicy) Merge(other *QualityPolicy) (*QualityPolicy, error) {
+	if p == nil {
+		p = &QualityPolicy{}
+	}
+	merged := *p
+	if other == nil {
+		return &merged, nil
+	}
+	if other.Codec != "" {
+		if merged.Codec != "" && !strings.EqualFold(merged.Codec, other.Codec) {
+			return nil, NewError(ErrNotAcceptable, "", "quality: codec %s isn't allowed, only %s", merged.Codec, other.Codec)
+		}
+		merged.Codec = other.Codec
+	}
+	if other.MaxBitRate > 0 && (merged.MaxBitRate == 0 || other.MaxBitRate < merged.MaxBitRate) {
+		merged.MaxBitRate = other.MaxBitRate
+	}
+	if other.MaxSampleRate > 0 && (merged.MaxSampleRate == 0 || other.MaxSampleRate < merged.MaxSampleRate) {
+		merged.MaxSampleRate = other.MaxSampleRate
+	}
+	merged.Lossless = merged.Lossless || other.Lossless
+	return &merged, nil
+}
+
+// QualityCap returns the caps an admin set for a user, combining the global caps with the user's own
+func (s *Service) QualityCap(user *ServiceUser) (*QualityPolicy, error) {
+	if s.Quality == nil {
+		return &QualityPolicy{}, nil
+	}
+	cap, err := s.Quality.Global.Merge(nil)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return cap, nil
+	}
+	for accessKey, userCap := range s.Quality.Users {
+		if userID(accessKey) == user.ID {
+			return cap.Merge(userCap)
+		}
+	}
+	return cap, nil
+}
+
+// NegotiateFormats returns the formats of a stream a user may be served, from best to worst, either the one requested with ?format or every format that satisfies the requested constraints
+func (s *Service) NegotiateFormats(stream *ObjectStream, user *ServiceUser, query url.Values) ([]*ObjectFormat, error) {
+	cap, err := s.QualityCap(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Get("format") != "" {
+		formatID, err := strconv.Atoi(query.Get("format"))
+		if err != nil {
+			return nil, NewError(ErrBadURI, stream.Provider, "quality: invalid format %s", query.Get("format"))
+		}
+		format := stream.GetFormat(formatID)
+		if format == nil {
+			return nil, NewError(ErrNotFound, stream.Provider, "quality: no format %d for stream %s", formatID, stream.URI)
+		}
+		if !cap.Allows(format) {
+			return nil, NewError(ErrNotAcceptable, stream.Provider, "quality: format %d exceeds your quality limits", formatID)
+		}
+		return []*ObjectFormat{format}, nil
+	}
+
+	request, err := qualityRequest(query)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := request.Merge(cap)
+	if err != nil {
+		return nil, err
+	}
+	formats := make([]*ObjectFormat, 0)
+	for i := 0; i < len(stream.Formats); i++ {
+		if stream.Formats[i] != nil && policy.Allows(stream.Formats[i]) {
+			formats = append(formats, stream.Formats[i])
+		}
+	}
+	if len(formats) == 0 {
+		if len(stream.Formats) == 0 {
+			return nil, NewError(ErrNotFound, stream.Provider, "quality: no formats available for stream %s", stream.URI)
+		}
+		return nil, NewError(ErrNotAcceptable, stream.Provider, "quality: no format of stream %s satisfies the requested quality within your limits", stream.URI)
+	}
+	return formats, nil
+}
+
+// qualityRequest returns the constraints a client asked for with ?codec, ?maxBitrate, ?maxSampleRate and ?lossless
+func qualityRequest(query url.Values) (*QualityPolicy, error) {
+	request := &QualityPolicy{Codec: strings.ToLower(query.Get("codec"))}
+	if maxBitRate := query.Get("maxBitrate"); maxBitRate != "" {
+		bitRate, err := strconv.ParseInt(maxBitRate, 10, 32)
+		if err != nil || bitRate <= 0 {
+			return nil, NewError(ErrBadURI, "", "quality: invalid maxBitrate %s", maxBitRate)
+		}
+		request.MaxBitRate = int32(bitRate)
+	}
+	if maxSampleRate := query.Get("maxSampleRate"); maxSampleRate != "" {
+		sampleRate, err := strconv.ParseInt(maxSampleRate, 10, 32)
+		if err != nil || sampleRate <= 0 {
+			return nil, NewError(ErrBadURI, "", "quality: invalid maxSampleRate %s", maxSampleRate)
+		}
+		request.MaxSampleRate = int32(sampleRate)
+	}
+	if lossless := query.Get("lossless"); lossless != "" {
+		var err error
+		if request.Lossless, err = strconv.ParseBool(lossless); err != nil {
+			return nil, NewError(ErrBadURI, "", "quality: invalid lossless %s", lossless)
+		}
+	}
+	return request, nil
+}
+
+// serveFormats tries each format in order until one is served, reporting the format in use with the X-Libremedia-Format header
+func serveFormats(w http.ResponseWriter, r *http.Request, stream *ObjectStream, formats []*ObjectFormat, serve func(http.ResponseWriter, *http.Request, *ObjectStream, int) error) (err error) {
+	for i := 0; i < len(formats); i++ {
+		if i > 0 {
+			Trace.Println("Selecting format " + formats[i].Name + " automatically")
+		}
+		w.Header().Set("X-Libremedia-Format", formatHeader(formats[i]))
+		err = serve(w, r, stream, formats[i].ID)
+		if err == nil {
+			return nil
+		}
+		errMsg := err.Error()
+		if strings.Contains(errMsg, "broken pipe") || strings.Contains(errMsg, "connection reset") {
+			return nil //The stream was successful, but interrupted
+		}
+	}
+	w.Header().Del("X-Libremedia-Format")
+	return err
+}
+
+// formatHeader describes a format for the X-Libremedia-Format header, ex: id=0; name=HI_RES; codec=flac; bitrate=9216000; samplerate=96000
+func formatHeader(format *ObjectFormat) string {
+	return fmt.Sprintf("id=%d; name=%s; codec=%s; bitrate=%d; samplerate=%d", format.ID, format.Name, format.Codec, format.BitRate, format.SampleRate)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestQualityPolicyMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *QualityPolicy
+		other   *QualityPolicy
+		want    *QualityPolicy
+		wantErr bool
+	}{
+		{name: "both nil", want: &QualityPolicy{}},
+		{name: "nil other", policy: &QualityPolicy{Codec: "flac"}, want: &QualityPolicy{Codec: "flac"}},
+		{name: "nil policy", other: &QualityPolicy{MaxBitRate: 320000}, want: &QualityPolicy{MaxBitRate: 320000}},
+		{
+			name:   "lowest limits win",
+			policy: &QualityPolicy{MaxBitRate: 320000, MaxSampleRate: 96000},
+			other:  &QualityPolicy{MaxBitRate: 1411000, MaxSampleRate: 44100},
+			want:   &QualityPolicy{MaxBitRate: 320000, MaxSampleRate: 44100},
+		},
+		{
+			name:   "unset limits don't lift set ones",
+			policy: &QualityPolicy{MaxBitRate: 320000},
+			other:  &QualityPolicy{MaxSampleRate: 44100},
+			want:   &QualityPolicy{MaxBitRate: 320000, MaxSampleRate: 44100},
+		},
+		{name: "lossless from either", policy: &QualityPolicy{}, other: &QualityPolicy{Lossless: true}, want: &QualityPolicy{Lossless: true}},
+		{name: "same codec in any case", policy: &QualityPolicy{Codec: "flac"}, other: &QualityPolicy{Codec: "FLAC"}, want: &QualityPolicy{Codec: "FLAC"}},
+		{name: "codec from other", policy: &QualityPolicy{MaxBitRate: 320000}, other: &QualityPolicy{Codec: "aac"}, want: &QualityPolicy{Codec: "aac", MaxBitRate: 320000}},
+		{name: "different codecs", policy: &QualityPolicy{Codec: "flac"}, other: &QualityPolicy{Codec: "aac"}, wantErr: true},
+	}
+	for _, test := range tests {
+		before := test.policy
+		if before != nil {
+			copied := *before
+			before = &copied
+		}
+		got, err := test.policy.Merge(test.other)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: merged into %+v, want error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: merged into %+v, want %+v", test.name, got, test.want)
+		}
+		if !reflect.DeepEqual(test.policy, before) {
+			t.Errorf("%s: merging changed the policy to %+v", test.name, test.policy)
+		}
+	}
+}
+
+func TestNegotiateFormats(t *testing.T) {
+	hiRes := &ObjectFormat{ID: 0, Name: "HI_RES", Codec: "flac", BitRate: 9216000, SampleRate: 96000}
+	lossless := &ObjectFormat{ID: 1, Name: "LOSSLESS", Codec: "flac", BitRate: 1411000, SampleRate: 44100}
+	high := &ObjectFormat{ID: 2, Name: "HIGH", Codec: "aac", BitRate: 320000, SampleRate: 44100}
+	low := &ObjectFormat{ID: 3, Name: "LOW", Codec: "aac", BitRate: 96000, SampleRate: 44100}
+	stream := &ObjectStream{URI: "tidal:track:1", Provider: "tidal", Formats: []*ObjectFormat{hiRes, lossless, high, low}}
+
+	capped := &ServiceUser{ID: userID("capped")}
+	s := &Service{Quality: &QualityConfig{
+		Global: &QualityPolicy{MaxSampleRate: 48000},
+		Users:  map[string]*QualityPolicy{"capped": {MaxBitRate: 320000}},
+	}}
+
+	tests := []struct {
+		name    string
+		service *Service
+		user    *ServiceUser
+		query   string
+		want    []*ObjectFormat
+		wantErr string
+	}{
+		{name: "no caps", service: &Service{}, want: []*ObjectFormat{hiRes, lossless, high, low}},
+		{name: "global cap", want: []*ObjectFormat{lossless, high, low}},
+		{name: "user cap", user: capped, want: []*ObjectFormat{high, low}},
+		{name: "requested codec", query: "codec=AAC", want: []*ObjectFormat{high, low}},
+		{name: "requested bitrate", query: "maxBitrate=100000", want: []*ObjectFormat{low}},
+		{name: "requested lossless", query: "lossless=true", want: []*ObjectFormat{lossless}},
+		{name: "requested format", query: "format=2", want: []*ObjectFormat{high}},
+		{name: "requested format beyond cap", user: capped, query: "format=1", wantErr: ErrNotAcceptable},
+		{name: "unknown format", query: "format=9", wantErr: ErrNotFound},
+		{name: "invalid format", query: "format=best", wantErr: ErrBadURI},
+		{name: "invalid bitrate", query: "maxBitrate=-1", wantErr: ErrBadURI},
+		{name: "invalid lossless", query: "lossless=maybe", wantErr: ErrBadURI},
+		{name: "nothing within caps", user: capped, query: "lossless=true", wantErr: ErrNotAcceptable},
+		{name: "codec against capped codec", service: &Service{Quality: &QualityConfig{Global: &QualityPolicy{Codec: "flac"}}}, query: "codec=aac", wantErr: ErrNotAcceptable},
+	}
+	for _, test := range tests {
+		service := s
+		if test.service != nil {
+			service = test.service
+		}
+		query, _ := url.ParseQuery(test.query)
+		got, err := service.NegotiateFormats(stream, test.user, query)
+		if test.wantErr != "" {
+			objErr, ok := err.(*ObjectError)
+			if !ok || objErr.Code != test.wantErr {
+				t.Errorf("%s: returned %v, %v, want error %s", test.name, got, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: negotiated %s, want %s", test.name, mustJSON(t, got), mustJSON(t, test.want))
+		}
+	}
+
+	if _, err := s.NegotiateFormats(&ObjectStream{URI: "tidal:track:2", Provider: "tidal"}, nil, url.Values{}); err == nil {
+		t.Errorf("negotiated a stream without formats, want error")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	queuePath    = "queues/"    //Where each user's queue is stored
+	playlistPath = "playlists/" //Where playlists saved by users are stored
+)
+
+var (
+	queues     = make(map[string]*ObjectQueue) //Queues loaded into memory by user ID
+	queuesLock sync.Mutex
+)
+
+// Queue runs an action against a user's queue while holding the queue lock, saving the queue afterwards if the action succeeded, and returns a copy of the queue
+func Queue(user *ServiceUser, action func(queue *ObjectQueue) error) (*ObjectQueue, error) {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+
+	queue, exists := queues[user.ID]
+	if !exists {
+		queue = &ObjectQueue{Streams: make([]*Object, 0)}
+		queueData, err := ioutil.ReadFile(queuePath + user.ID + ".json")
+		if err == nil {
+			if err := json.Unmarshal(queueData, queue); err != nil {
+				Warning.Printf("Dropping unreadable queue of %s: %v\n", user.ID, err)
+				queue = &ObjectQueue{Streams: make([]*Object, 0)}
+			}
+		}
+		queues[user.ID] = queue
+	}
+	if action != nil {
+		if err := action(queue); err != nil {
+			return nil, err
+		}
+		os.MkdirAll(queuePath, 0777)
+		if err := writeFileAtomic(queuePath+user.ID+".json", queue.JSON(), 0644); err != nil {
+			Error.Printf("Failed to save queue of %s: %v\n", user.ID, err)
+		}
+	}
+
+	//Return a copy, so it can be served after the lock is released
+	snapshot := &ObjectQueue{Shuffle: queue.Shuffle}
+	snapshot.Streams = append(make([]*Object, 0, len(queue.Streams)), queue.Streams...)
+	if queue.Order != nil {
+		snapshot.Order = append(make([]int, 0, len(queue.Order)), queue.Order...)
+	}
+	return snapshot, nil
+}
+
+// QueueStreams returns references to the streams an object holds, so an album or playlist queues every stream within it
+func QueueStreams(uri string) ([]*Object, error) {
+	obj := GetObject(uri)
+	if obj == nil {
+		return nil, NewError(ErrNotFound, "", "queue: no object for %s", uri)
+	}
+	if objErr := obj.Err(); objErr != nil {
+		return nil, objErr
+	}
+	streams := make([]*Object, 0)
+	switch obj.Type {
+	case "stream":
+		streams = append(streams, obj)
+	case "album":
+		if album := obj.Album(); album != nil {
+			for i := 0; i < len(album.Discs); i++ {
+				streams = append(streams, album.Discs[i].Streams...)
+			}
+		}
+	case "playlist":
+		if playlist := obj.Playlist(); playlist != nil {
+			streams = append(streams, playlist.Streams...)
+		}
+	default:
+		return nil, NewError(ErrBadURI, obj.Provider, "queue: can't queue a %s", obj.Type)
+	}
+
+	refs := make([]*Object, 0, len(streams))
+	for i := 0; i < len(streams); i++ {
+		if streams[i] != nil && streams[i].URI != "" {
+			refs = append(refs, queueRef(streams[i]))
+		}
+	}
+	return refs, nil
+}
+
+// queueRef returns a reference to a stream with just enough metadata to list it, as the full stream is fetched when it plays
+func queueRef(obj *Object) *Object {
+	ref := &Object{URI: obj.URI, Type: "stream", Provider: obj.Provider}
+	if stream := obj.Stream(); stream != nil {
+		ref.Object = &ObjectStream{
+			Provider: stream.Provider,
+			URI:      stream.URI,
+			Name:     stream.Name,
+			Duration: stream.Duration,
+			Creators: stream.Creators,
+		}
+	}
+	return ref
+}
+
+// SavePlaylist saves streams as a new libremedia playlist and returns it
+func SavePlaylist(name string, streams []*Object) (*Object, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, WrapError(err, "libremedia", "playlist: couldn't generate an ID")
+	}
+	uri := "libremedia:playlist:" + hex.EncodeToString(id)
+	obj := &Object{
+		URI:      uri,
+		Type:     "playlist",
+		Provider: "libremedia",
+		Object: &ObjectPlaylist{
+			Provider: "libremedia",
+			URI:      uri,
+			Name:     name,
+			Streams:  append([]*Object{}, streams...),
+		},
+	}
+	objData, err := obj.JSON()
+	if err != nil {
+		return nil, WrapError(err, "libremedia", "playlist: couldn't encode %s", uri)
+	}
+	path := playlistPath + hex.EncodeToString(id) + ".json"
+	os.MkdirAll(filepath.Dir(path), 0777)
+	if err := writeFileAtomic(path, objData, 0644); err != nil {
+		return nil, WrapError(err, "libremedia", "playlist: couldn't save %s", uri)
+	}
+	return obj, nil
+}
+
+// GetPlaylist returns a playlist saved by a user
+func GetPlaylist(id string) *Object {
+	playlistData, err := ioutil.ReadFile(playlistPath + filepath.Base(id) + ".json")
+	if err != nil {
+		return NewObjError(NewError(ErrNotFound, "libremedia", "playlist: no playlist %s", id))
+	}
+	obj := &Object{}
+	if err := json.Unmarshal(playlistData, obj); err != nil {
+		return NewObjError(WrapError(err, "libremedia", "playlist: couldn't read %s", id))
+	}
+	return obj
+}
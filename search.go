@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	searchTimeout       = time.Second * 10 //How long to wait for each provider to return search results by default
+	searchMergeDuration = 3                //How many seconds two streams may differ in duration and still be merged
+)
+
+// Search queries the given providers in parallel, or every provider if none are given, and returns whatever they found before the search timeout
+func (s *Service) Search(query string, filter []string) (results *ObjectSearchResults) {
+	results = &ObjectSearchResults{Query: query, Provider: "libremedia"}
+
+	searching := make([]string, 0)
+	if len(filter) == 0 {
+		searching = append(searching, providers...)
+	} else {
+		for i := 0; i < len(filter); i++ {
+			if _, exists := GetHandler(filter[i]); !exists {
+				results.Errors = append(results.Errors, NewError(ErrNotFound, filter[i], "search: no provider %s", filter[i]))
+				continue
+			}
+			searching = append(searching, filter[i])
+		}
+	}
+
+	type searchResult struct {
+		index   int
+		results *ObjectSearchResults
+		err     error
+	}
+	found := make([]*searchResult, len(searching))
+	done := make(chan *searchResult, len(searching))
+	pending := 0
+	for i := 0; i < len(searching); i++ {
+		provider := searching[i]
+		if err := s.Health(provider); err != nil {
+			found[i] = &searchResult{index: i, err: NewError(ErrProviderUnavailable, provider, "search: provider %s is unavailable: %v", provider, err)}
+			continue
+		}
+		handler, _ := GetHandler(provider)
+		pending++
+		go func(index int, handler Handler) {
+			Trace.Println("Searching for '" + query + "' on " + handler.Provider())
+			res, err := handler.Search(query)
+			done <- &searchResult{index: index, results: res, err: err}
+		}(i, handler)
+	}
+
+	//Stop waiting at the deadline, leaving any provider that didn't respond in time without results
+	timeout := time.After(s.searchTimeout())
+collect:
+	for ; pending > 0; pending-- {
+		select {
+		case result := <-done:
+			found[result.index] = result
+		case <-timeout:
+			break collect
+		}
+	}
+
+	//Merge in provider order so results don't shuffle between searches
+	for i := 0; i < len(searching); i++ {
+		result := found[i]
+		if result == nil {
+			results.Errors = append(results.Errors, NewError(ErrProviderUnavailable, searching[i], "search: provider %s timed out after %v", searching[i], s.searchTimeout()))
+			continue
+		}
+		if result.err != nil {
+			Error.Printf("Error searching on %s: %v", searching[i], result.err)
+			results.Errors = append(results.Errors, WrapError(result.err, searching[i], "search"))
+			continue
+		}
+		if result.results == nil {
+			continue
+		}
+		results.Creators = append(results.Creators, result.results.Creators...)
+		results.Albums = append(results.Albums, result.results.Albums...)
+		results.Streams = append(results.Streams, result.results.Streams...)
+	}
+	results.Merge()
+	return
+}
+
+// searchTimeout returns how long to wait for each provider to return search results
+func (s *Service) searchTimeout() time.Duration {
+	if s.SearchTimeout != "" {
+		duration, err := time.ParseDuration(s.SearchTimeout)
+		if err == nil {
+			return duration
+		}
+		Warning.Printf("Invalid search timeout %s, using the default: %v\n", s.SearchTimeout, err)
+	}
+	return searchTimeout
+}
+
+// searchFilter returns the providers a search is restricted to, from either repeated or comma separated providers params
+func searchFilter(params url.Values) []string {
+	seen := make(map[string]bool)
+	filter := make([]string, 0)
+	for _, values := range params["providers"] {
+		for _, provider := range strings.Split(values, ",") {
+			provider = strings.ToLower(strings.TrimSpace(provider))
+			if provider == "" || seen[provider] {
+				continue
+			}
+			seen[provider] = true
+			filter = append(filter, provider)
+		}
+	}
+	sort.Strings(filter)
+	return filter
+}
+
+// Merge clusters equivalent results from different providers into one result each, keeping the first result of each cluster and listing the URIs of the rest as its alternatives
+func (obj *ObjectSearchResults) Merge() {
+	obj.Streams = mergeObjects(obj.Streams, sameStream)
+	obj.Albums = mergeObjects(obj.Albums, sameAlbum)
+	obj.Creators = mergeObjects(obj.Creators, sameCreator)
+}
+
+// mergeObjects returns the given objects with equivalent objects merged, never merging two objects from the same provider
+func mergeObjects(objects []*Object, same func(a, b *Object) bool) []*Object {
+	merged := make([]*Object, 0, len(objects))
+	clusters := make([]map[string]bool, 0, len(objects)) //The providers already within each merged object
+	for i := 0; i < len(objects); i++ {
+		obj := objects[i]
+		if obj == nil {
+			continue
+		}
+		cluster := -1
+		for j := 0; j < len(merged); j++ {
+			if !clusters[j][obj.Provider] && same(merged[j], obj) {
+				cluster = j
+				break
+			}
+		}
+		if cluster == -1 {
+			merged = append(merged, obj)
+			clusters = append(clusters, map[string]bool{obj.Provider: true})
+			continue
+		}
+		clusters[cluster][obj.Provider] = true
+		merged[cluster].Alternatives = append(merged[cluster].Alternatives, obj.URI)
+		merged[cluster].Alternatives = append(merged[cluster].Alternatives, obj.Alternatives...)
+	}
+	return merged
+}
+
+// sameStream returns true if both objects are the same recording, by ISRC if both have one or otherwise by name, creator and duration
+func sameStream(a, b *Object) bool {
+	streamA, streamB := a.Stream(), b.Stream()
+	if streamA == nil || streamB == nil {
+		return false
+	}
+	if streamA.ISRC != "" && streamB.ISRC != "" {
+		return strings.EqualFold(streamA.ISRC, streamB.ISRC)
+	}
+	if streamA.Duration > 0 && streamB.Duration > 0 {
+		diff := streamA.Duration - streamB.Duration
+		if diff > searchMergeDuration || diff < -searchMergeDuration {
+			return false
+		}
+	}
+	return sameName(streamA.Name, streamB.Name) && sameCreators(streamA.Creators, streamB.Creators)
+}
+
+// sameAlbum returns true if both objects are the same release, by UPC if both have one or otherwise by name and creator
+func sameAlbum(a, b *Object) bool {
+	albumA, albumB := a.Album(), b.Album()
+	if albumA == nil || albumB == nil {
+		return false
+	}
+	if albumA.UPC != "" && albumB.UPC != "" {
+		return normalizeUPC(albumA.UPC) == normalizeUPC(albumB.UPC)
+	}
+	return sameName(albumA.Name, albumB.Name) && sameCreators(albumA.Creators, albumB.Creators)
+}
+
+// sameCreator returns true if both objects are creators with the same name
+func sameCreator(a, b *Object) bool {
+	creatorA, creatorB := a.Creator(), b.Creator()
+	if creatorA == nil || creatorB == nil {
+		return false
+	}
+	return sameName(creatorA.Name, creatorB.Name)
+}
+
+// sameCreators returns true if both lists are led by the same creator, and false if either is empty as there's nothing to compare
+func sameCreators(a, b []*Object) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	creatorA, creatorB := a[0].Creator(), b[0].Creator()
+	if creatorA == nil || creatorB == nil {
+		return false
+	}
+	return sameName(creatorA.Name, creatorB.Name)
+}
+
+// sameName returns true if both names match once case, punctuation and spacing are ignored
+func sameName(a, b string) bool {
+	a, b = normalizeName(a), normalizeName(b)
+	return a != "" && a == b
+}
+
+// normalizeName returns a name in lowercase with only its letters and digits, each word separated by a single space
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// normalizeUPC returns a UPC without leading zeroes, so a 12 digit UPC matches its 13 digit EAN form
+func normalizeUPC(upc string) string {
+	return strings.TrimLeft(strings.TrimSpace(upc), "0")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testStream(provider, id, name, creator, isrc string, duration int64) *Object {
+	return &Object{
+		URI:      provider + ":track:" + id,
+		Type:     "stream",
+		Provider: provider,
+		Object: &ObjectStream{
+			Name:     name,
+			ISRC:     isrc,
+			Duration: duration,
+			Creators: []*Object{{Type: "creator", Provider: provider, Object: &ObjectCreator{Name: creator}}},
+		},
+	}
+}
+
+func TestSameStream(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *Object
+		want bool
+	}{
+		{"same isrc", testStream("tidal", "1", "One More Time", "Daft Punk", "GBDUW0000059", 320), testStream("spotify", "1", "One More Time - Radio Edit", "Daft Punk", "gbduw0000059", 240), true},
+		{"different isrc", testStream("tidal", "1", "One More Time", "Daft Punk", "GBDUW0000059", 320), testStream("spotify", "1", "One More Time", "Daft Punk", "GBDUW0000060", 320), false},
+		{"same metadata", testStream("tidal", "1", "One More Time", "Daft Punk", "", 320), testStream("spotify", "1", "one more time!", "DAFT  PUNK", "", 322), true},
+		{"one isrc missing", testStream("tidal", "1", "One More Time", "Daft Punk", "GBDUW0000059", 320), testStream("spotify", "1", "One More Time", "Daft Punk", "", 320), true},
+		{"durations too far apart", testStream("tidal", "1", "One More Time", "Daft Punk", "", 320), testStream("spotify", "1", "One More Time", "Daft Punk", "", 324), false},
+		{"unknown duration", testStream("tidal", "1", "One More Time", "Daft Punk", "", 0), testStream("spotify", "1", "One More Time", "Daft Punk", "", 320), true},
+		{"different name", testStream("tidal", "1", "One More Time", "Daft Punk", "", 320), testStream("spotify", "1", "Aerodynamic", "Daft Punk", "", 320), false},
+		{"different creator", testStream("tidal", "1", "One More Time", "Daft Punk", "", 320), testStream("spotify", "1", "One More Time", "Justice", "", 320), false},
+		{"empty name", testStream("tidal", "1", "", "Daft Punk", "", 320), testStream("spotify", "1", "", "Daft Punk", "", 320), false},
+		{"not a stream", testStream("tidal", "1", "One More Time", "Daft Punk", "", 320), &Object{Type: "album", Object: &ObjectAlbum{Name: "One More Time"}}, false},
+	}
+	for _, test := range tests {
+		if got := sameStream(test.a, test.b); got != test.want {
+			t.Errorf("%s: sameStream = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSearchMerge(t *testing.T) {
+	tidal := testStream("tidal", "1", "One More Time", "Daft Punk", "GBDUW0000059", 320)
+	spotify := testStream("spotify", "2", "One More Time", "Daft Punk", "GBDUW0000059", 320)
+	tidalLive := testStream("tidal", "3", "One More Time (Live)", "Daft Punk", "GBDUW0700001", 330)
+	spotifyLive := testStream("spotify", "4", "One More Time (Live)", "Daft Punk", "", 331)
+	tidalAgain := testStream("tidal", "5", "One More Time", "Daft Punk", "GBDUW0000059", 320)
+	results := &ObjectSearchResults{
+		Streams: []*Object{tidal, tidalLive, nil, spotify, tidalAgain, spotifyLive},
+		Albums: []*Object{
+			{URI: "tidal:album:1", Type: "album", Provider: "tidal", Object: &ObjectAlbum{Name: "Discovery", UPC: "0724384960650"}},
+			{URI: "spotify:album:1", Type: "album", Provider: "spotify", Object: &ObjectAlbum{Name: "Discovery (Remastered)", UPC: "724384960650"}},
+		},
+		Creators: []*Object{
+			{URI: "tidal:artist:1", Type: "creator", Provider: "tidal", Object: &ObjectCreator{Name: "Daft Punk"}},
+			{URI: "spotify:artist:1", Type: "creator", Provider: "spotify", Object: &ObjectCreator{Name: "Daft Punk"}},
+			{URI: "spotify:artist:2", Type: "creator", Provider: "spotify", Object: &ObjectCreator{Name: "Daft Punk"}},
+		},
+	}
+	results.Merge()
+
+	//Two results from the same provider are never merged, even if they look the same
+	wantStreams := map[string][]string{
+		"tidal:track:1": {"spotify:track:2"},
+		"tidal:track:3": {"spotify:track:4"},
+		"tidal:track:5": nil,
+	}
+	if len(results.Streams) != len(wantStreams) {
+		t.Fatalf("merged into %d streams, want %d", len(results.Streams), len(wantStreams))
+	}
+	order := []string{"tidal:track:1", "tidal:track:3", "tidal:track:5"}
+	for i, uri := range order {
+		if results.Streams[i].URI != uri {
+			t.Errorf("stream %d = %s, want %s", i, results.Streams[i].URI, uri)
+		}
+		if !reflect.DeepEqual(results.Streams[i].Alternatives, wantStreams[uri]) {
+			t.Errorf("%s alternatives = %v, want %v", uri, results.Streams[i].Alternatives, wantStreams[uri])
+		}
+	}
+	if len(results.Albums) != 1 || !reflect.DeepEqual(results.Albums[0].Alternatives, []string{"spotify:album:1"}) {
+		t.Errorf("albums merged into %d, want one with spotify:album:1 as its alternative", len(results.Albums))
+	}
+	if len(results.Creators) != 2 || !reflect.DeepEqual(results.Creators[0].Alternatives, []string{"spotify:artist:1"}) {
+		t.Errorf("creators merged into %d, want two with spotify:artist:1 as the first's alternative", len(results.Creators))
+	}
+}
+
+func TestExpandObjectKeepsAlternatives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libremedia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	service.Offline = true
+	defer func() { service.Offline = false }()
+
+	cached := testStream("tidal", "1", "One More Time", "Daft Punk", "", 320)
+	cached.Alternatives = []string{"spotify:track:9"}
+	cached.Sync()
+
+	ref := testStream("tidal", "1", "One More Time", "Daft Punk", "", 320)
+	ref.Alternatives = []string{"spotify:track:2", "spotify:track:9"}
+	expanded := expandObject(ref)
+	if expanded == ref {
+		t.Fatalf("expandObject returned the reference, want the cached object")
+	}
+	want := []string{"spotify:track:9", "spotify:track:2"}
+	if !reflect.DeepEqual(expanded.Alternatives, want) {
+		t.Errorf("expanded alternatives = %v, want %v", expanded.Alternatives, want)
+	}
+	if again := GetObjectCached(ref.URI); !reflect.DeepEqual(again.Alternatives, []string{"spotify:track:9"}) {
+		t.Errorf("cached alternatives became %v, want them left alone", again.Alternatives)
+	}
+}
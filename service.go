@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -11,9 +16,20 @@ var (
 		"tidal":   &TidalClient{},
 		"spotify": &SpotifyClient{},
 	}
-	providers = make([]string, 0)
+	handlersLock sync.RWMutex
+	providers    = make([]string, 0)      //Every active provider, whether or not it logged in
+	logins       = make(map[string]error) //Why each provider failed to log in, nil once it has
+	loggingIn    = make(map[string]bool)  //Providers that are retrying their login
 )
 
+// GetHandler returns the handler of a provider, which may not be logged in yet
+func GetHandler(provider string) (Handler, bool) {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	handler, exists := handlers[provider]
+	return handler, exists
+}
+
 type Handler interface {
 	Provider() string                             //Used for service identification
 	SetService(*Service)                          //Provides the handler access to the libremedia service
@@ -22,10 +38,18 @@ type Handler interface {
 	Album(id string) (*ObjectAlbum, error)        //Returns the matching album object for metadata
 	Stream(id string) (*ObjectStream, error)      //Returns the matching stream object for metadata
 	StreamFormat(w http.ResponseWriter, r *http.Request, stream *ObjectStream, format int) error
-	FormatList() []*ObjectFormat                       //Returns all the possible formats as templates ordered from best to worst
-	Search(query string) (*ObjectSearchResults, error) //Returns all the available search results that match the query
-	Transcribe(obj *ObjectStream) error                //Fills in the stream's transcript with lyrics, closed captioning, subtitles, etc
-	ReplaceURI(text string) string                     //Replaces all instances of a URI with a libremedia-acceptable URI, for dynamic hyperlinking
+	FormatList() []*ObjectFormat                             //Returns all the possible formats as templates ordered from best to worst
+	Search(query string) (*ObjectSearchResults, error)       //Returns all the available search results that match the query
+	Transcribe(ctx context.Context, obj *ObjectStream) error //Fills in the stream's transcript with lyrics, closed captioning, subtitles, etc, giving up once ctx is done
+	ReplaceURI(text string) string                           //Replaces all instances of a URI with a libremedia-acceptable URI, for dynamic hyperlinking
+	Health() error                                           //Returns an error if the provider can't be reached or is no longer authenticated
+	About() *ObjectProvider                                  //Returns the display name, upstream icon and capabilities of the provider
+}
+
+// IdentifierHandler is implemented by handlers that can look up objects by standard identifiers
+type IdentifierHandler interface {
+	LookupISRC(isrc string) (string, error) //Returns the URI of the stream with the given ISRC
+	LookupUPC(upc string) (string, error)   //Returns the URI of the album with the given UPC
 }
 
 type HandlerConfig struct {
@@ -37,50 +61,158 @@ type HandlerConfig struct {
 }
 
 type Service struct {
-	AccessKeys []string                  `json:"accessKeys"`
-	BaseURL    string                    `json:"baseURL"`
-	Handlers   map[string]*HandlerConfig `json:"handlers"`
-	HostAddr   string                    `json:"httpAddr"`
+	AccessKeys     []string                  `json:"accessKeys"`
+	AdminKeys      []string                  `json:"adminKeys"`
+	BaseURL        string                    `json:"baseURL"`
+	Cache          *CacheConfig              `json:"cache"`
+	Handlers       map[string]*HandlerConfig `json:"handlers"`
+	HostAddr       string                    `json:"httpAddr"`
+	Offline        bool                      `json:"offline"`        //Serve only from the cache, regardless of provider health
+	HealthInterval string                    `json:"healthInterval"` //How often to check provider health, ex: 5m
+	SearchTimeout  string                    `json:"searchTimeout"`  //How long to wait for each provider to return search results, ex: 10s
+
+	PreferredProviders []string `json:"preferredProviders"` //The providers to favour when scoring a bestmatch, from most to least preferred
+	SigningKey         string   `json:"signingKey"`         //The secret used to sign stream URLs, random on every run if left out
+	SignedURLExpiry    string   `json:"signedURLExpiry"`    //How long a signed stream URL works for, ex: 2h
+
+	Quality      *QualityConfig       `json:"quality"`      //The quality caps set by an admin, globally and per access key
+	Transcribers []*TranscriberConfig `json:"transcribers"` //The transcribers to try in order, ex: source, sidecar, lyrics
+
+	transcribers []*transcriberLink
 
 	Grants map[string]*ServiceUser `json:"-"`
 }
 
+// Providers returns the details and current status of every active provider, including any that failed to log in
+func (s *Service) Providers() []*ObjectProvider {
+	about := make([]*ObjectProvider, 0)
+	for i := 0; i < len(providers); i++ {
+		handler, exists := GetHandler(providers[i])
+		if !exists {
+			continue
+		}
+		provider := handler.About()
+		provider.Icon = s.BaseURL + "v1/providers/" + providers[i] + "/icon"
+		if err := s.LoginError(providers[i]); err != nil {
+			provider.Authenticated = false
+			provider.Error = err.Error()
+		} else if err := s.Health(providers[i]); err != nil {
+			provider.Error = err.Error()
+		} else {
+			provider.Healthy = true
+		}
+		about = append(about, provider)
+	}
+	return about
+}
+
 func (s *Service) Login() error {
 	if s.BaseURL[len(s.BaseURL)-1] != '/' {
 		s.BaseURL += "/"
 	}
+	var loginErr error
 	for provider, config := range s.Handlers {
-		if handler, exists := handlers[provider]; exists {
+		if _, exists := handlers[provider]; exists {
 			if config.Active {
-				newHandler, err := handler.Authenticate(config)
-				if err != nil {
-					Error.Println("Failed to authenticate " + provider + ": ", err)
-					return err
-				}
-				newHandler.SetService(s)
-				handlers[provider] = newHandler
+				//Keep providers that fail to log in so their status is reported and their login retried
 				providers = append(providers, provider)
+				if err := s.login(provider, config); err != nil {
+					loginErr = err
+				}
 			} else {
 				Trace.Println("Skipping authenticating " + provider)
 				delete(handlers, provider)
 			}
 		}
 	}
+	if s.IsOffline() {
+		Warning.Println("Running in offline mode, serving only from the cache")
+	}
+	return loginErr
+}
+
+// login authenticates a provider, replacing its handler if successful and marking it unhealthy if not
+func (s *Service) login(provider string, config *HandlerConfig) error {
+	handler, _ := GetHandler(provider)
+	newHandler, err := handler.Authenticate(config)
+	if err != nil {
+		//Keep going so the remaining providers and the cache stay available
+		Error.Println("Failed to authenticate "+provider+": ", err)
+		handlersLock.Lock()
+		logins[provider] = err
+		handlersLock.Unlock()
+		s.SetHealth(provider, err)
+		return err
+	}
+	newHandler.SetService(s)
+	handlersLock.Lock()
+	handlers[provider] = newHandler
+	logins[provider] = nil
+	handlersLock.Unlock()
+	s.SetHealth(provider, nil)
 	return nil
 }
 
+// retryLogin authenticates a provider that failed to log in, unless it's already trying
+func (s *Service) retryLogin(provider string) {
+	handlersLock.Lock()
+	if loggingIn[provider] {
+		handlersLock.Unlock()
+		return
+	}
+	loggingIn[provider] = true
+	handlersLock.Unlock()
+	defer func() {
+		handlersLock.Lock()
+		delete(loggingIn, provider)
+		handlersLock.Unlock()
+	}()
+
+	Trace.Println("Retrying login for " + provider)
+	if err := s.login(provider, s.Handlers[provider]); err == nil {
+		Info.Println("Logged in to " + provider)
+	}
+}
+
+// LoginError returns why a provider failed to log in, or nil if it's logged in
+func (s *Service) LoginError(provider string) error {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	return logins[provider]
+}
+
+// Auth returns the user holding an access key, or a shared guest user if no access keys are configured
 func (s *Service) Auth(accessKey string) (*ServiceUser, error) {
-	allow := false
-	for i := 0; i < len(s.AccessKeys); i++ {
-		if s.AccessKeys[i] == accessKey {
-			allow = true
-			break
-		}
+	if !s.RequiresAuth() {
+		return &ServiceUser{ID: "guest"}, nil
+	}
+	if !s.IsAdmin(accessKey) && !matchKey(s.AccessKeys, accessKey) {
+		return nil, NewError(ErrUnauthorized, "", "invalid accessKey")
 	}
-	if !allow {
-		return nil, fmt.Errorf("invalid accessKey")
+	return &ServiceUser{ID: userID(accessKey)}, nil
+}
+
+// userID returns the stable ID of the user holding an access key, without revealing the key
+func userID(accessKey string) string {
+	sum := sha256.Sum256([]byte(accessKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// IsAdmin returns true if the given access key has administrative rights
+func (s *Service) IsAdmin(accessKey string) bool {
+	if accessKey == "" {
+		return false
 	}
-	return nil, nil
+	return matchKey(s.AdminKeys, accessKey)
+}
+
+// matchKey returns true if a key is one of the given keys, comparing every key in constant time so the response time doesn't reveal how much of a key was right
+func matchKey(keys []string, key string) bool {
+	match := 0
+	for i := 0; i < len(keys); i++ {
+		match |= subtle.ConstantTimeCompare([]byte(keys[i]), []byte(key))
+	}
+	return match == 1
 }
 
 func (s *Service) Stream(w http.ResponseWriter, r *http.Request, stream *ObjectStream, format int) error {
@@ -101,7 +233,10 @@ func (s *Service) Stream(w http.ResponseWriter, r *http.Request, stream *ObjectS
 			return fmt.Errorf("stream not available right now")
 		}
 	}
-*/	if handler, exists := handlers[stream.Provider]; exists {
+*/	if err := s.LoginError(stream.Provider); err != nil {
+		return NewError(ErrProviderUnavailable, stream.Provider, "%s: not logged in: %v", stream.Provider, err)
+	}
+	if handler, exists := GetHandler(stream.Provider); exists {
 		return handler.StreamFormat(w, r, stream, format)
 	}
 	return fmt.Errorf("no handler for provider " + stream.Provider)
@@ -111,13 +246,18 @@ func (s *Service) Download(w http.ResponseWriter, r *http.Request, stream *Objec
 	if stream.Provider == "" {
 		return fmt.Errorf("provider not specified")
 	}
-	if handler, exists := handlers[stream.Provider]; exists {
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+stream.FileName()+"\"")
+	if err := s.LoginError(stream.Provider); err != nil {
+		return NewError(ErrProviderUnavailable, stream.Provider, "%s: not logged in: %v", stream.Provider, err)
+	}
+	if handler, exists := GetHandler(stream.Provider); exists {
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+stream.FileName(format)+"\"")
 		return handler.StreamFormat(w, r, stream, format)
 	}
 	return fmt.Errorf("no handler for provider " + stream.Provider)
 }
 
+// ServiceUser holds a user of libremedia
 type ServiceUser struct {
+	ID      string //An anonymous ID derived from the user's access key, used to store their queue and player
 	Expires time.Time
 }
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestAuth(t *testing.T) {
+	s := &Service{AccessKeys: []string{"alice", "bob"}, AdminKeys: []string{"root"}}
+	tests := []struct {
+		key   string
+		allow bool
+		admin bool
+	}{
+		{key: "alice", allow: true},
+		{key: "bob", allow: true},
+		{key: "root", allow: true, admin: true},
+		{key: "alic", allow: false},
+		{key: "alice2", allow: false},
+		{key: "", allow: false},
+	}
+	for _, test := range tests {
+		user, err := s.Auth(test.key)
+		if test.allow != (err == nil) {
+			t.Errorf("Auth(%q) = %+v, %v, want allowed %v", test.key, user, err, test.allow)
+		}
+		if test.allow && user.ID != userID(test.key) {
+			t.Errorf("Auth(%q) returned user %s, want %s", test.key, user.ID, userID(test.key))
+		}
+		if got := s.IsAdmin(test.key); got != test.admin {
+			t.Errorf("IsAdmin(%q) = %v, want %v", test.key, got, test.admin)
+		}
+	}
+
+	if user, err := (&Service{}).Auth(""); err != nil || user.ID != "guest" {
+		t.Errorf("Auth without keys configured = %+v, %v, want guest", user, err)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	sessionTick        = time.Second      //How often live members are sent the playback position
+	sessionSaverTicks  = 10               //How many ticks data saver members wait between positions
+	sessionIdleTimeout = time.Minute * 10 //How long a session with no members is kept before it ends
+	sessionSendBuffer  = 16               //How many messages may wait for a slow member before it misses some
+	sessionRestart     = 3                //How many seconds into a stream going back restarts it instead of playing the previous one
+)
+
+// Policies for who may append to the queue of a shared session
+const (
+	SessionAppendHost     = "host"     //Only the host may append
+	SessionAppendRotation = "rotation" //Members take turns in the order they joined
+	SessionAppendFree     = "free"     //Anyone may append at any time
+)
+
+// Sync modes a member can choose
+const (
+	SessionModeLive  = "live"  //Sent the position every tick for the lowest latency
+	SessionModeSaver = "saver" //Sent the position less often to save data
+)
+
+var (
+	sessions     = make(map[string]*Session) //Shared sessions by ID
+	sessionsLock sync.Mutex
+)
+
+// Session holds the authoritative state of a shared listening session
+type Session struct {
+	sync.Mutex
+
+	ID         string           `json:"id"`
+	Invite     string           `json:"invite"`          //The link to share to invite others to this session
+	VoteSkip   bool             `json:"voteSkip"`        //Whether or not members may vote to skip the playing stream
+	Append     string           `json:"append"`          //Who may append to the queue, ex: host, rotation, free
+	Queue      *ObjectQueue     `json:"queue"`           //The queue everyone in this session is listening through
+	NowPlaying int              `json:"nowPlaying"`      //The index of the playing stream within the queue's view, or -1 if nothing is playing
+	Position   float64          `json:"position"`        //The playback position in seconds as of when it was last updated
+	Paused     bool             `json:"paused"`          //Whether or not playback is paused
+	Updated    time.Time        `json:"updated"`         //When the playback position was last updated
+	Members    []*SessionMember `json:"members"`         //The connected members, in the order they joined
+	Votes      map[string]bool  `json:"votes,omitempty"` //The members voting to skip the playing stream
+	Turn       int              `json:"turn"`            //The index of the member whose turn it is to append, under the rotation policy
+
+	hostToken string    //The secret the creator presents to join as the host
+	empty     time.Time //When the last member left
+}
+
+// SessionMember holds one connection to a shared session
+type SessionMember struct {
+	ID   string `json:"id"`
+	Host bool   `json:"host"` //Whether or not this member is the host
+	Mode string `json:"mode"` //How often this member is sent the position, ex: live, saver
+
+	send  chan []byte
+	ticks int
+}
+
+// SessionMessage is sent between members and the server over a session's WebSocket
+type SessionMessage struct {
+	Type       string       `json:"type"`                 //Sent by members: play, pause, resume, seek, next, prev, append, voteskip, settings, mode, ping. Sent by the server: state, position, pong, error
+	Index      *int         `json:"index,omitempty"`      //The index to play within the queue's view
+	Position   *float64     `json:"position,omitempty"`   //The playback position in seconds
+	NowPlaying *int         `json:"nowPlaying,omitempty"` //The index of the playing stream within the queue's view
+	Paused     *bool        `json:"paused,omitempty"`     //Whether or not playback is paused
+	URI        string       `json:"uri,omitempty"`        //The object to append to the queue
+	Mode       string       `json:"mode,omitempty"`       //The sync mode to switch to, ex: live, saver
+	VoteSkip   *bool        `json:"voteSkip,omitempty"`   //Whether or not to allow voting to skip
+	Append     string       `json:"append,omitempty"`     //The append policy to switch to
+	ClientTime int64        `json:"clientTime,omitempty"` //The member's clock in milliseconds when it sent a ping, echoed back for clock sync
+	ServerTime int64        `json:"serverTime,omitempty"` //The server's clock in milliseconds when it sent this message
+	Session    *Session     `json:"session,omitempty"`    //The full state of the session
+	HostToken  string       `json:"hostToken,omitempty"`  //The secret that makes a connection the host, only sent to the creator of the session
+	Error      *ObjectError `json:"error,omitempty"`      //Why the last message was rejected
+}
+
+// NewSession starts a shared session hosted by a user, starting from a copy of their queue
+func NewSession(host *ServiceUser, voteSkip bool, appendPolicy string) (*Session, error) {
+	switch appendPolicy {
+	case "":
+		appendPolicy = SessionAppendHost
+	case SessionAppendHost, SessionAppendRotation, SessionAppendFree:
+	default:
+		return nil, NewError(ErrBadURI, "", "sessions: no append policy %s, try host, rotation or free", appendPolicy)
+	}
+	queue, err := Queue(host, nil)
+	if err != nil {
+		return nil, err
+	}
+	id, err := sessionID()
+	if err != nil {
+		return nil, err
+	}
+	hostToken, err := sessionToken()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		ID:         id,
+		Invite:     service.BaseURL + "v1/sessions/" + id,
+		VoteSkip:   voteSkip,
+		Append:     appendPolicy,
+		Queue:      queue,
+		NowPlaying: -1,
+		Updated:    time.Now(),
+		Members:    make([]*SessionMember, 0),
+		Votes:      make(map[string]bool),
+		hostToken:  hostToken,
+		empty:      time.Now(),
+	}
+	sessionsLock.Lock()
+	sessions[id] = session
+	sessionsLock.Unlock()
+	go session.run()
+	return session, nil
+}
+
+// GetSession returns a shared session by its ID
+func GetSession(id string) *Session {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	return sessions[id]
+}
+
+// State returns the full state of the session encoded as JSON
+func (s *Session) State() []byte {
+	s.Lock()
+	defer s.Unlock()
+	return s.message(&SessionMessage{Type: "state", Session: s})
+}
+
+// HostState returns the full state of the session encoded as JSON along with its host token, for the creator of the session only
+func (s *Session) HostState() []byte {
+	s.Lock()
+	defer s.Unlock()
+	return s.message(&SessionMessage{Type: "state", Session: s, HostToken: s.hostToken})
+}
+
+// IsHostToken returns true if a token is this session's host token
+func (s *Session) IsHostToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.hostToken)) == 1
+}
+
+// Serve runs a member's WebSocket connection until it closes, joining as the host if it presented the host token
+func (s *Session) Serve(ws *websocket.Conn, hostToken, mode string) {
+	if mode != SessionModeSaver {
+		mode = SessionModeLive
+	}
+	id, err := sessionID()
+	if err != nil {
+		return
+	}
+	member := &SessionMember{ID: id, Host: s.IsHostToken(hostToken), Mode: mode, send: make(chan []byte, sessionSendBuffer)}
+
+	s.Lock()
+	s.Members = append(s.Members, member)
+	s.broadcastState()
+	s.Unlock()
+
+	go func() {
+		for data := range member.send {
+			if err := websocket.Message.Send(ws, string(data)); err != nil {
+				ws.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		msg := &SessionMessage{}
+		if err := websocket.JSON.Receive(ws, msg); err != nil {
+			break
+		}
+		s.handle(member, msg)
+	}
+	ws.Close()
+	s.leave(member)
+}
+
+// handle applies a message from a member, enforcing the host's policies
+func (s *Session) handle(member *SessionMember, msg *SessionMessage) {
+	//Fetching the streams to append may take a while, so do it before taking the lock
+	var streams []*Object
+	var fetchErr error
+	if msg.Type == "append" {
+		streams, fetchErr = QueueStreams(msg.URI)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	switch msg.Type {
+	case "ping":
+		s.send(member, &SessionMessage{Type: "pong", ClientTime: msg.ClientTime})
+		return
+	case "mode":
+		if msg.Mode != SessionModeLive && msg.Mode != SessionModeSaver {
+			s.reject(member, NewError(ErrBadURI, "", "sessions: no mode %s, try live or saver", msg.Mode))
+			return
+		}
+		member.Mode = msg.Mode
+	case "play", "pause", "resume", "seek", "next", "prev", "settings":
+		if !member.Host {
+			s.reject(member, NewError(ErrUnauthorized, "", "sessions: only the host may %s", msg.Type))
+			return
+		}
+		if err := s.control(msg); err != nil {
+			s.reject(member, err)
+			return
+		}
+	case "append":
+		if fetchErr != nil {
+			s.reject(member, fetchErr)
+			return
+		}
+		if err := s.canAppend(member); err != nil {
+			s.reject(member, err)
+			return
+		}
+		s.Queue.Append(streams...)
+		if s.Append == SessionAppendRotation && s.Turn < len(s.Members) && s.Members[s.Turn] == member {
+			s.Turn = (s.Turn + 1) % len(s.Members)
+		}
+	case "voteskip":
+		if !s.VoteSkip {
+			s.reject(member, NewError(ErrUnauthorized, "", "sessions: the host hasn't allowed voting to skip"))
+			return
+		}
+		if s.NowPlaying < 0 {
+			s.reject(member, NewError(ErrBadURI, "", "sessions: nothing is playing"))
+			return
+		}
+		s.Votes[member.ID] = true
+		if len(s.Votes)*2 > len(s.Members) {
+			s.next()
+		}
+	default:
+		s.reject(member, NewError(ErrBadURI, "", "sessions: unknown message type %s", msg.Type))
+		return
+	}
+	s.broadcastState()
+}
+
+// control applies a playback or settings change from the host
+func (s *Session) control(msg *SessionMessage) error {
+	switch msg.Type {
+	case "play":
+		if msg.Index == nil || *msg.Index < 0 || *msg.Index >= len(s.Queue.View()) {
+			return NewError(ErrBadURI, "", "sessions: need index within the queue to play")
+		}
+		s.play(*msg.Index)
+	case "pause":
+		s.Position = s.position()
+		s.Paused = true
+		s.Updated = time.Now()
+	case "resume":
+		s.Updated = time.Now()
+		s.Paused = false
+	case "seek":
+		if msg.Position == nil || *msg.Position < 0 {
+			return NewError(ErrBadURI, "", "sessions: need position to seek to")
+		}
+		s.Position = *msg.Position
+		s.Updated = time.Now()
+	case "next":
+		s.next()
+	case "prev":
+		if s.position() > sessionRestart || s.NowPlaying <= 0 {
+			s.play(s.NowPlaying)
+		} else {
+			s.play(s.NowPlaying - 1)
+		}
+	case "settings":
+		switch msg.Append {
+		case "":
+		case SessionAppendHost, SessionAppendRotation, SessionAppendFree:
+			s.Append = msg.Append
+		default:
+			return NewError(ErrBadURI, "", "sessions: no append policy %s, try host, rotation or free", msg.Append)
+		}
+		if msg.VoteSkip != nil {
+			s.VoteSkip = *msg.VoteSkip
+			s.Votes = make(map[string]bool)
+		}
+	}
+	return nil
+}
+
+// canAppend returns an error if a member may not append to the queue right now
+func (s *Session) canAppend(member *SessionMember) error {
+	if member.Host {
+		return nil
+	}
+	switch s.Append {
+	case SessionAppendFree:
+		return nil
+	case SessionAppendRotation:
+		if s.Turn < len(s.Members) && s.Members[s.Turn] == member {
+			return nil
+		}
+		return NewError(ErrUnauthorized, "", "sessions: it isn't your turn to append")
+	}
+	return NewError(ErrUnauthorized, "", "sessions: only the host may append")
+}
+
+// play starts playing the stream at an index of the queue's view from the beginning, or stops if there isn't one
+func (s *Session) play(index int) {
+	if index < 0 || index >= len(s.Queue.View()) {
+		index = -1
+	}
+	s.NowPlaying = index
+	s.Position = 0
+	s.Paused = index == -1
+	s.Updated = time.Now()
+	s.Votes = make(map[string]bool)
+}
+
+// next plays the next stream in the queue's view
+func (s *Session) next() {
+	s.play(s.NowPlaying + 1)
+}
+
+// position returns the current playback position in seconds
+func (s *Session) position() float64 {
+	if s.Paused || s.NowPlaying < 0 {
+		return s.Position
+	}
+	return s.Position + time.Since(s.Updated).Seconds()
+}
+
+// duration returns the duration in seconds of the playing stream, or 0 if it isn't known
+func (s *Session) duration() float64 {
+	view := s.Queue.View()
+	if s.NowPlaying < 0 || s.NowPlaying >= len(view) {
+		return 0
+	}
+	if stream := view[s.NowPlaying].Stream(); stream != nil {
+		return float64(stream.Duration)
+	}
+	return 0
+}
+
+// leave removes a member from the session
+func (s *Session) leave(member *SessionMember) {
+	s.Lock()
+	defer s.Unlock()
+	for i := 0; i < len(s.Members); i++ {
+		if s.Members[i] != member {
+			continue
+		}
+		s.Members = append(s.Members[:i], s.Members[i+1:]...)
+		if i < s.Turn {
+			s.Turn--
+		}
+		break
+	}
+	if s.Turn >= len(s.Members) {
+		s.Turn = 0
+	}
+	delete(s.Votes, member.ID)
+	close(member.send)
+	if len(s.Members) == 0 {
+		s.empty = time.Now()
+	}
+	s.broadcastState()
+}
+
+// run advances playback when a stream ends and sends the position to members, until the session has been empty for too long
+func (s *Session) run() {
+	ticker := time.NewTicker(sessionTick)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.Lock()
+		if len(s.Members) == 0 && time.Since(s.empty) > sessionIdleTimeout {
+			s.Unlock()
+			sessionsLock.Lock()
+			delete(sessions, s.ID)
+			sessionsLock.Unlock()
+			Trace.Println("Ended idle session " + s.ID)
+			return
+		}
+		if duration := s.duration(); duration > 0 && s.position() >= duration {
+			s.next()
+			s.broadcastState()
+		}
+		nowPlaying, position, paused := s.NowPlaying, s.position(), s.Paused
+		data := s.message(&SessionMessage{Type: "position", NowPlaying: &nowPlaying, Position: &position, Paused: &paused})
+		for i := 0; i < len(s.Members); i++ {
+			member := s.Members[i]
+			member.ticks++
+			if member.Mode == SessionModeLive || member.ticks%sessionSaverTicks == 0 {
+				s.queue(member, data)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// broadcastState sends the full state of the session to every member, the caller must hold the lock
+func (s *Session) broadcastState() {
+	data := s.message(&SessionMessage{Type: "state", Session: s})
+	for i := 0; i < len(s.Members); i++ {
+		s.queue(s.Members[i], data)
+	}
+}
+
+// send sends a message to one member, the caller must hold the lock
+func (s *Session) send(member *SessionMember, msg *SessionMessage) {
+	s.queue(member, s.message(msg))
+}
+
+// reject tells a member why its last message was rejected, the caller must hold the lock
+func (s *Session) reject(member *SessionMember, err error) {
+	objErr, ok := err.(*ObjectError)
+	if !ok {
+		objErr = WrapError(err, "", "sessions")
+	}
+	s.send(member, &SessionMessage{Type: "error", Error: objErr})
+}
+
+// message stamps a message with the server's clock and encodes it, the caller must hold the lock
+func (s *Session) message(msg *SessionMessage) []byte {
+	msg.ServerTime = time.Now().UnixNano() / int64(time.Millisecond)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		Error.Printf("Failed to encode session message: %v\n", err)
+		return nil
+	}
+	return data
+}
+
+// queue hands encoded data to a member's connection, dropping it if the member has fallen too far behind
+func (s *Session) queue(member *SessionMember, data []byte) {
+	if data == nil {
+		return
+	}
+	select {
+	case member.send <- data:
+	default:
+		Trace.Println("Dropping session message for slow member " + member.ID)
+	}
+}
+
+// sessionID returns a new random ID for a session or member
+func sessionID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", WrapError(err, "libremedia", "sessions: couldn't generate an ID")
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// sessionToken returns a new random secret for a session's host
+func sessionToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", WrapError(err, "libremedia", "sessions: couldn't generate a host token")
+	}
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSessionHostToken(t *testing.T) {
+	token, err := sessionToken()
+	if err != nil {
+		t.Fatalf("failed to generate a host token: %v", err)
+	}
+	session := &Session{ID: "abc", Members: make([]*SessionMember, 0), hostToken: token}
+
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{token: token, want: true},
+		{token: "", want: false},
+		{token: token[:len(token)-1], want: false},
+		{token: "guest", want: false},
+	}
+	for _, test := range tests {
+		if got := session.IsHostToken(test.token); got != test.want {
+			t.Errorf("IsHostToken(%q) = %v, want %v", test.token, got, test.want)
+		}
+	}
+	if (&Session{}).IsHostToken("") {
+		t.Errorf("a session without a host token accepted an empty one")
+	}
+
+	//Only the creator of the session may see the host token
+	msg := &SessionMessage{}
+	if err := json.Unmarshal(session.State(), msg); err != nil {
+		t.Fatalf("failed to decode state: %v", err)
+	}
+	if msg.HostToken != "" {
+		t.Errorf("State() leaked the host token")
+	}
+	msg = &SessionMessage{}
+	if err := json.Unmarshal(session.HostState(), msg); err != nil {
+		t.Fatalf("failed to decode host state: %v", err)
+	}
+	if msg.HostToken != token {
+		t.Errorf("HostState() has host token %q, want %q", msg.HostToken, token)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	signedURLExpiry = time.Hour * 2 //How long a signed stream URL works for by default
+)
+
+var (
+	signingKey     []byte //The key used when the configuration doesn't provide one, generated once per run
+	signingKeyOnce sync.Once
+)
+
+// RequiresAuth returns true if any access or admin keys are configured, so requests need one of them or a signed URL
+func (s *Service) RequiresAuth() bool {
+	return len(s.AccessKeys) > 0 || len(s.AdminKeys) > 0
+}
+
+// SignURL returns a stream URL for a format that works without an access key until it expires
+func (s *Service) SignURL(uri string, format int, user *ServiceUser) string {
+	if parsedURI, err := ParseURI(uri); err == nil {
+		uri = parsedURI.Base().String()
+	}
+	expires := strconv.FormatInt(time.Now().Add(s.signedURLExpiry()).Unix(), 10)
+	query := url.Values{}
+	query.Set("format", strconv.Itoa(format))
+	query.Set("user", user.ID)
+	query.Set("expires", expires)
+	query.Set("sig", s.signature(uri, query.Get("format"), user.ID, expires))
+	return s.BaseURL + "v1/stream/" + uri + "?" + query.Encode()
+}
+
+// VerifySignedURL returns the user a stream URL was signed for, or an error if its signature is wrong or it expired
+func (s *Service) VerifySignedURL(uri string, query url.Values) (*ServiceUser, error) {
+	if parsedURI, err := ParseURI(uri); err == nil {
+		uri = parsedURI.Base().String()
+	}
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil {
+		return nil, NewError(ErrUnauthorized, "", "invalid signature")
+	}
+	expected, _ := hex.DecodeString(s.signature(uri, query.Get("format"), query.Get("user"), query.Get("expires")))
+	if !hmac.Equal(sig, expected) {
+		return nil, NewError(ErrUnauthorized, "", "invalid signature")
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, NewError(ErrUnauthorized, "", "signed url expired")
+	}
+	return &ServiceUser{ID: query.Get("user"), Expires: time.Unix(expires, 0)}, nil
+}
+
+// AuthStream returns the user a stream or download request is for, checking that it carries either a valid signature or an access key when one is required
+func (s *Service) AuthStream(r *http.Request, uri string) (*ServiceUser, error) {
+	query := r.URL.Query()
+	if query.Get("sig") != "" {
+		return s.VerifySignedURL(uri, query)
+	}
+	return s.Auth(getAccessKey(r))
+}
+
+// SignObject returns a copy of an object with the format URLs of every stream within it signed for a user, leaving the original untouched as it may be cached
+func (s *Service) SignObject(obj *Object, user *ServiceUser) *Object {
+	if obj == nil || obj.Object == nil {
+		return obj
+	}
+	signed := *obj
+	switch payload := obj.Object.(type) {
+	case *ObjectStream:
+		return s.SignStream(obj, user)
+	case *ObjectAlbum:
+		album := *payload
+		album.Creators = s.signObjects(payload.Creators, user)
+		if payload.Discs != nil {
+			album.Discs = make([]*ObjectDisc, len(payload.Discs))
+			for i := 0; i < len(payload.Discs); i++ {
+				if payload.Discs[i] == nil {
+					continue
+				}
+				disc := *payload.Discs[i]
+				disc.Streams = s.signObjects(disc.Streams, user)
+				album.Discs[i] = &disc
+			}
+		}
+		signed.Object = &album
+	case *ObjectCreator:
+		creator := *payload
+		creator.Albums = s.signObjects(payload.Albums, user)
+		creator.TopStreams = s.signObjects(payload.TopStreams, user)
+		creator.Appearances = s.signObjects(payload.Appearances, user)
+		creator.Singles = s.signObjects(payload.Singles, user)
+		creator.Playlists = s.signObjects(payload.Playlists, user)
+		creator.Related = s.signObjects(payload.Related, user)
+		signed.Object = &creator
+	case *ObjectSearchResults:
+		results := *payload
+		results.Streams = s.signObjects(payload.Streams, user)
+		results.Creators = s.signObjects(payload.Creators, user)
+		results.Albums = s.signObjects(payload.Albums, user)
+		signed.Object = &results
+	case *ObjectPlaylist:
+		playlist := *payload
+		playlist.Creators = s.signObjects(payload.Creators, user)
+		playlist.Streams = s.signObjects(payload.Streams, user)
+		signed.Object = &playlist
+	case *ObjectCharts:
+		charts := *payload
+		if payload.Entries != nil {
+			charts.Entries = make([]*ObjectChartEntry, len(payload.Entries))
+			for i := 0; i < len(payload.Entries); i++ {
+				if payload.Entries[i] == nil {
+					continue
+				}
+				entry := *payload.Entries[i]
+				entry.Object = s.SignObject(entry.Object, user)
+				charts.Entries[i] = &entry
+			}
+		}
+		signed.Object = &charts
+	default:
+		return obj
+	}
+	return &signed
+}
+
+// SignStream returns a copy of a stream object with every format URL signed for a user, along with those of the streams nested within it
+func (s *Service) SignStream(obj *Object, user *ServiceUser) *Object {
+	stream := obj.Stream()
+	if stream == nil {
+		return obj
+	}
+	signedStream := *stream
+	signedStream.Formats = make([]*ObjectFormat, len(stream.Formats))
+	for i := 0; i < len(stream.Formats); i++ {
+		if stream.Formats[i] == nil {
+			continue
+		}
+		format := *stream.Formats[i]
+		format.URL = s.SignURL(obj.URI, format.ID, user)
+		signedStream.Formats[i] = &format
+	}
+	signedStream.Creators = s.signObjects(stream.Creators, user)
+	signedStream.Album = s.SignObject(stream.Album, user)
+	signed := *obj
+	signed.Object = &signedStream
+	return &signed
+}
+
+// SignQueue returns a copy of a queue with every stream within it signed for a user
+func (s *Service) SignQueue(queue *ObjectQueue, user *ServiceUser) *ObjectQueue {
+	if queue == nil {
+		return nil
+	}
+	signed := *queue
+	signed.Streams = s.signObjects(queue.Streams, user)
+	return &signed
+}
+
+// SignPlayer returns a copy of a player with its playing stream signed for a user
+func (s *Service) SignPlayer(player *PlayerState, user *ServiceUser) *PlayerState {
+	if player == nil {
+		return nil
+	}
+	signed := *player
+	signed.Stream = s.SignObject(player.Stream, user)
+	return &signed
+}
+
+// signObjects returns a copy of a list of objects with every stream within them signed for a user
+func (s *Service) signObjects(objs []*Object, user *ServiceUser) []*Object {
+	if objs == nil {
+		return nil
+	}
+	signed := make([]*Object, len(objs))
+	for i := 0; i < len(objs); i++ {
+		signed[i] = s.SignObject(objs[i], user)
+	}
+	return signed
+}
+
+// signature returns the HMAC of everything a signed URL embeds
+func (s *Service) signature(uri, format, user, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey())
+	mac.Write([]byte(uri + "\n" + format + "\n" + user + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signingKey returns the configured signing key, or a random one that lasts until libremedia restarts
+func (s *Service) signingKey() []byte {
+	if s.SigningKey != "" {
+		return []byte(s.SigningKey)
+	}
+	signingKeyOnce.Do(func() {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			Error.Printf("Failed to generate a signing key: %v\n", err)
+		}
+		Warning.Println("No signingKey configured, signed URLs will stop working when libremedia restarts")
+	})
+	return signingKey
+}
+
+// signedURLExpiry returns how long a signed stream URL works for
+func (s *Service) signedURLExpiry() time.Duration {
+	if s.SignedURLExpiry != "" {
+		duration, err := time.ParseDuration(s.SignedURLExpiry)
+		if err == nil {
+			return duration
+		}
+		Warning.Printf("Invalid signed URL expiry %s, using the default: %v\n", s.SignedURLExpiry, err)
+	}
+	return signedURLExpiry
+}
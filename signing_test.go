@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignedURL(t *testing.T) {
+	s := &Service{BaseURL: "http://localhost/", SigningKey: "test"}
+	user := &ServiceUser{ID: "alice"}
+	signed, err := url.Parse(s.SignURL("tidal:track:1", 2, user))
+	if err != nil {
+		t.Fatalf("failed to parse signed url: %v", err)
+	}
+	if !strings.HasPrefix(signed.Path, "/v1/stream/tidal:track:1") {
+		t.Errorf("signed url %s doesn't lead to the stream", signed)
+	}
+	valid := signed.Query()
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	with := func(key, value string) url.Values {
+		query := url.Values{}
+		for k, v := range valid {
+			query[k] = v
+		}
+		query.Set(key, value)
+		return query
+	}
+	expiredQuery := with("expires", expired)
+	expiredQuery.Set("sig", s.signature("tidal:track:1", "2", "alice", expired))
+
+	tests := []struct {
+		name    string
+		uri     string
+		query   url.Values
+		service *Service
+		wantErr bool
+	}{
+		{name: "valid", uri: "tidal:track:1", query: valid},
+		{name: "other stream", uri: "tidal:track:2", query: valid, wantErr: true},
+		{name: "other format", uri: "tidal:track:1", query: with("format", "3"), wantErr: true},
+		{name: "other user", uri: "tidal:track:1", query: with("user", "mallory"), wantErr: true},
+		{name: "extended expiry", uri: "tidal:track:1", query: with("expires", "99999999999"), wantErr: true},
+		{name: "expired", uri: "tidal:track:1", query: expiredQuery, wantErr: true},
+		{name: "mangled signature", uri: "tidal:track:1", query: with("sig", "zz"), wantErr: true},
+		{name: "empty signature", uri: "tidal:track:1", query: with("sig", ""), wantErr: true},
+		{name: "other signing key", uri: "tidal:track:1", query: valid, service: &Service{SigningKey: "other"}, wantErr: true},
+	}
+	for _, test := range tests {
+		verifier := s
+		if test.service != nil {
+			verifier = test.service
+		}
+		got, err := verifier.VerifySignedURL(test.uri, test.query)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: verified as %+v, want error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+			continue
+		}
+		if got.ID != user.ID {
+			t.Errorf("%s: verified as user %s, want %s", test.name, got.ID, user.ID)
+		}
+	}
+}
+
+func TestSignObjectNested(t *testing.T) {
+	s := &Service{BaseURL: "http://localhost/", SigningKey: "test"}
+	user := &ServiceUser{ID: "alice"}
+	stream := func(id string) *Object {
+		return &Object{URI: "tidal:track:" + id, Type: "stream", Provider: "tidal", Object: &ObjectStream{
+			Name:    id,
+			Formats: []*ObjectFormat{{ID: 0, URL: "http://localhost/v1/stream/tidal:track:" + id}},
+		}}
+	}
+	album := &Object{URI: "tidal:album:1", Type: "album", Provider: "tidal", Object: &ObjectAlbum{Discs: []*ObjectDisc{{Streams: []*Object{stream("1")}}}}}
+	creator := &Object{URI: "tidal:artist:1", Type: "creator", Provider: "tidal", Object: &ObjectCreator{TopStreams: []*Object{stream("2")}, Albums: []*Object{album}}}
+	nested := stream("3")
+	nested.Stream().Album = album
+
+	tests := []struct {
+		obj  *Object
+		find func(obj *Object) *Object
+	}{
+		{obj: stream("4"), find: func(obj *Object) *Object { return obj }},
+		{obj: nested, find: func(obj *Object) *Object { return obj.Stream().Album.Album().Discs[0].Streams[0] }},
+		{obj: album, find: func(obj *Object) *Object { return obj.Album().Discs[0].Streams[0] }},
+		{obj: creator, find: func(obj *Object) *Object { return obj.Creator().TopStreams[0] }},
+		{obj: creator, find: func(obj *Object) *Object { return obj.Creator().Albums[0].Album().Discs[0].Streams[0] }},
+		{
+			obj:  &Object{URI: "search:x", Type: "search", Object: &ObjectSearchResults{Streams: []*Object{stream("5")}}},
+			find: func(obj *Object) *Object { return obj.SearchResults().Streams[0] },
+		},
+		{
+			obj:  &Object{URI: "libremedia:playlist:1", Type: "playlist", Object: &ObjectPlaylist{Streams: []*Object{stream("6")}}},
+			find: func(obj *Object) *Object { return obj.Playlist().Streams[0] },
+		},
+		{
+			obj:  &Object{URI: "charts:streams", Type: "charts", Object: &ObjectCharts{Entries: []*ObjectChartEntry{{Count: 1, Object: stream("7")}}}},
+			find: func(obj *Object) *Object { return obj.Charts().Entries[0].Object },
+		},
+	}
+	for _, test := range tests {
+		before := mustJSON(t, test.obj)
+		signed := s.SignObject(test.obj, user)
+		if url := test.find(signed).Stream().Formats[0].URL; !strings.Contains(url, "sig=") {
+			t.Errorf("%s: nested stream has unsigned url %s", test.obj.URI, url)
+		}
+		if after := mustJSON(t, test.obj); after != before {
+			t.Errorf("%s: signing changed the original:\n got %s\nwant %s", test.obj.URI, after, before)
+		}
+	}
+
+	queue := s.SignQueue(&ObjectQueue{Streams: []*Object{stream("8")}}, user)
+	if url := queue.Streams[0].Stream().Formats[0].URL; !strings.Contains(url, "sig=") {
+		t.Errorf("queued stream has unsigned url %s", url)
+	}
+	player := s.SignPlayer(&PlayerState{Stream: stream("9")}, user)
+	if url := player.Stream.Stream().Formats[0].URL; !strings.Contains(url, "sig=") {
+		t.Errorf("playing stream has unsigned url %s", url)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -8,14 +9,18 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/eolso/librespot-golang/Spotify"
 	"github.com/eolso/librespot-golang/librespot"
 	"github.com/eolso/librespot-golang/librespot/core"
 	"github.com/eolso/librespot-golang/librespot/mercury"
 	"github.com/eolso/librespot-golang/librespot/utils"
+	"github.com/golang/protobuf/proto"
 )
 
 /*
@@ -38,6 +43,19 @@ func (s *SpotifyClient) Provider() string {
 	return "spotify"
 }
 
+// About returns the display name, icon and capabilities of Spotify
+func (s *SpotifyClient) About() *ObjectProvider {
+	return &ObjectProvider{
+		Provider:      s.Provider(),
+		Name:          "Spotify",
+		Icon:          "https://open.spotify.com/favicon.ico",
+		Types:         []string{"creator", "album", "stream"},
+		Formats:       s.FormatList(),
+		Transcribes:   true,
+		Authenticated: s.Session != nil,
+	}
+}
+
 func (s *SpotifyClient) SetService(service *Service) {
 	s.Service = service
 }
@@ -78,6 +96,18 @@ func (s *SpotifyClient) Authenticate(cfg *HandlerConfig) (handler Handler, err e
 	return s, nil
 }
 
+// Health checks that the Spotify session is still connected to Mercury
+func (s *SpotifyClient) Health() error {
+	if s.Session == nil {
+		return fmt.Errorf("spotify: no session")
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.Session.Mercury().Suggest("libremedia")
+	return err
+}
+
 // NewSpotify authenticates to Spotify and returns a Spotify session
 func NewSpotify(username, password, deviceName, blobPath string) (*SpotifyClient, error) {
 	if username == "" || password == "" || blobPath == "" {
@@ -108,37 +138,87 @@ func NewSpotify(username, password, deviceName, blobPath string) (*SpotifyClient
 	return &SpotifyClient{Session: session}, nil
 }
 
-func (s *SpotifyClient) mercuryGet(url string) []byte {
+// mercuryGet returns the payload of a Mercury request, giving up once ctx is done
+func (s *SpotifyClient) mercuryGet(ctx context.Context, url string) ([]byte, error) {
 	m := s.Session.Mercury()
-	done := make(chan []byte)
+	done := make(chan mercury.Response, 1) //Buffered so a late response doesn't block Mercury after giving up
 	go m.Request(mercury.Request{
 		Method:  "GET",
 		Uri:     url,
 		Payload: [][]byte{},
 	}, func(res mercury.Response) {
-		done <- res.CombinePayload()
+		done <- res
 	})
 
-	result := <-done
-	return result
+	var result mercury.Response
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		return nil, NewError(ErrProviderUnavailable, "spotify", "spotify: gave up on %s: %v", url, ctx.Err())
+	}
+	if err := spotifyMercuryError(url, result.StatusCode); err != nil {
+		return nil, err
+	}
+	return result.CombinePayload(), nil
 }
 
-func (s *SpotifyClient) mercuryGetJson(url string, result interface{}) (err error) {
-	data := s.mercuryGet(url)
+func (s *SpotifyClient) mercuryGetJson(ctx context.Context, url string, result interface{}) (err error) {
+	data, err := s.mercuryGet(ctx, url)
+	if err != nil {
+		return err
+	}
 	//Trace.Printf("Spotify Mercury JSON: %s\n", data)
 	err = json.Unmarshal(data, result)
 	return
 }
 
+func (s *SpotifyClient) mercuryGetProto(url string, result proto.Message) (err error) {
+	data, err := s.mercuryGet(context.Background(), url)
+	if err != nil {
+		return err
+	}
+	err = proto.Unmarshal(data, result)
+	if err != nil {
+		return NewError(ErrProviderUnavailable, "spotify", "spotify: invalid response from %s: %v", url, err)
+	}
+	return
+}
+
+// spotifyMercuryError maps a Mercury status code onto a libremedia error, or nil if it succeeded
+func spotifyMercuryError(url string, status int32) error {
+	code := ""
+	switch {
+	case status == 0 || (status >= 200 && status < 300): //Mercury may omit the status when a request succeeds
+		return nil
+	case status == 404:
+		code = ErrNotFound
+	case status == 429:
+		code = ErrRateLimited
+	case status == 401 || status == 403:
+		code = ErrUnauthorized
+	case status == 451:
+		code = ErrRegionRestricted
+	case status >= 500:
+		code = ErrProviderUnavailable
+	default:
+		code = ErrInternal
+	}
+	return NewError(code, "spotify", "spotify: mercury returned %d for %s", status, url)
+}
+
 // Creator gets an artist object from Spotify
 func (s *SpotifyClient) Creator(creatorID string) (creator *ObjectCreator, err error) {
 	s.Lock()
 	defer s.Unlock()
 
-	spotCreator, err := s.Session.Mercury().GetArtist(utils.Base62ToHex(creatorID))
+	spotCreator := &Spotify.Artist{}
+	err = s.mercuryGetProto("hm://metadata/4/artist/"+utils.Base62ToHex(creatorID), spotCreator)
 	if err != nil {
 		return nil, err
 	}
+	if spotCreator.Name == nil {
+		return nil, NewError(ErrNotFound, "spotify", "spotify: no artist %s", creatorID)
+	}
 	biography := ""
 	if len(spotCreator.Biography) > 0 {
 		spotBios := spotCreator.Biography
@@ -163,9 +243,8 @@ func (s *SpotifyClient) Creator(creatorID string) (creator *ObjectCreator, err e
 				URI: "spotify:track:" + gid2Id(topTrack.Gid),
 				Type:     "stream",
 				Provider: "spotify",
-				Object: &json.RawMessage{},
+				Object:   objStream,
 			}
-			obj.Object.UnmarshalJSON(objStream.JSON())
 			topTracks = append(topTracks, obj)
 		}
 	}
@@ -178,9 +257,8 @@ func (s *SpotifyClient) Creator(creatorID string) (creator *ObjectCreator, err e
 				URI: "spotify:album:" + gid2Id(spotAlbum.Gid),
 				Type:     "album",
 				Provider: "spotify",
-				Object: &json.RawMessage{},
+				Object:   objAlbum,
 			}
-			obj.Object.UnmarshalJSON(objAlbum.JSON())
 			albums = append(albums, obj)
 		}
 	}
@@ -193,9 +271,8 @@ func (s *SpotifyClient) Creator(creatorID string) (creator *ObjectCreator, err e
 				URI: "spotify:album:" + gid2Id(spotAlbum.Gid),
 				Type:     "album",
 				Provider: "spotify",
-				Object: &json.RawMessage{},
+				Object:   objAlbum,
 			}
-			obj.Object.UnmarshalJSON(objAlbum.JSON())
 			appearances = append(appearances, obj)
 		}
 	}
@@ -208,9 +285,8 @@ func (s *SpotifyClient) Creator(creatorID string) (creator *ObjectCreator, err e
 				URI: "spotify:album:" + gid2Id(spotAlbum.Gid),
 				Type:     "album",
 				Provider: "spotify",
-				Object: &json.RawMessage{},
+				Object:   objAlbum,
 			}
-			obj.Object.UnmarshalJSON(objAlbum.JSON())
 			singles = append(singles, obj)
 		}
 	}
@@ -225,9 +301,8 @@ func (s *SpotifyClient) Creator(creatorID string) (creator *ObjectCreator, err e
 			URI:  "spotify:artist:" + gid2Id(relatedCreator.Gid),
 			Type:     "creator",
 			Provider: "spotify",
-			Object: &json.RawMessage{},
+			Object:   objCreator,
 		}
-		related[i].Object.UnmarshalJSON(objCreator.JSON())
 	}
 	creator = &ObjectCreator{
 		URI:         "spotify:artist:" + creatorID,
@@ -239,6 +314,7 @@ func (s *SpotifyClient) Creator(creatorID string) (creator *ObjectCreator, err e
 		Appearances: appearances,
 		Singles:     singles,
 		Related:     related,
+		Popularity:  int(spotCreator.GetPopularity()),
 	}
 	return
 }
@@ -248,10 +324,14 @@ func (s *SpotifyClient) Album(albumID string) (album *ObjectAlbum, err error) {
 	s.Lock()
 	defer s.Unlock()
 
-	spotAlbum, err := s.Session.Mercury().GetAlbum(utils.Base62ToHex(albumID))
+	spotAlbum := &Spotify.Album{}
+	err = s.mercuryGetProto("hm://metadata/4/album/"+utils.Base62ToHex(albumID), spotAlbum)
 	if err != nil {
 		return nil, err
 	}
+	if spotAlbum.Name == nil {
+		return nil, NewError(ErrNotFound, "spotify", "spotify: no album %s", albumID)
+	}
 	creators := make([]*Object, len(spotAlbum.Artist))
 	for i := 0; i < len(creators); i++ {
 		objCreator := &ObjectCreator{
@@ -262,9 +342,8 @@ func (s *SpotifyClient) Album(albumID string) (album *ObjectAlbum, err error) {
 			URI:  "spotify:artist:" + gid2Id(spotAlbum.Artist[i].Gid),
 			Type:     "creator",
 			Provider: "spotify",
-			Object: &json.RawMessage{},
+			Object:   objCreator,
 		}
-		creators[i].Object.UnmarshalJSON(objCreator.JSON())
 	}
 	discs := make([]*ObjectDisc, len(spotAlbum.Disc))
 	for i := 0; i < len(discs); i++ {
@@ -279,9 +358,8 @@ func (s *SpotifyClient) Album(albumID string) (album *ObjectAlbum, err error) {
 				URI: "spotify:track:" + gid2Id(spotTrack.Gid),
 				Type:     "stream",
 				Provider: "spotify",
-				Object: &json.RawMessage{},
+				Object:   objStream,
 			}
-			discStreams[j].Object.UnmarshalJSON(objStream.JSON())
 		}
 		discs[i] = &ObjectDisc{
 			Streams: discStreams,
@@ -303,12 +381,14 @@ func (s *SpotifyClient) Album(albumID string) (album *ObjectAlbum, err error) {
 		Creators:   creators,
 		Discs:      discs,
 		Copyrights: copyrights,
+		UPC:        spotifyExternalID(spotAlbum.ExternalId, "upc"),
+		Popularity: int(spotAlbum.GetPopularity()),
 	}
 	if spotAlbum.Label != nil {
 		album.Label = *spotAlbum.Label
 	}
 	if spotAlbum.Date != nil {
-		date := *spotAlbum.Date
+		date := spotAlbum.Date
 		dateTime := ""
 		if date.Year != nil && date.Month != nil {
 			dateTime = fmt.Sprintf("%d-%d", *date.Year, *date.Month)
@@ -332,10 +412,14 @@ func (s *SpotifyClient) Stream(trackID string) (stream *ObjectStream, err error)
 	s.Lock()
 	defer s.Unlock()
 
-	spotTrack, err := s.Session.Mercury().GetTrack(utils.Base62ToHex(trackID))
+	spotTrack := &Spotify.Track{}
+	err = s.mercuryGetProto("hm://metadata/4/track/"+utils.Base62ToHex(trackID), spotTrack)
 	if err != nil {
 		return nil, err
 	}
+	if spotTrack.Name == nil {
+		return nil, NewError(ErrNotFound, "spotify", "spotify: no track %s", trackID)
+	}
 	creators := make([]*Object, len(spotTrack.Artist))
 	for i := 0; i < len(creators); i++ {
 		creator := spotTrack.Artist[i]
@@ -347,57 +431,22 @@ func (s *SpotifyClient) Stream(trackID string) (stream *ObjectStream, err error)
 			URI:  "spotify:artist:" + gid2Id(creator.Gid),
 			Type:     "creator",
 			Provider: "spotify",
-			Object: &json.RawMessage{},
+			Object:   objCreator,
 		}
-		creators[i].Object.UnmarshalJSON(objCreator.JSON())
 	}
+	//List only the formats this track has, from best to worst
 	formats := make([]*ObjectFormat, 0)
 	formatList := s.FormatList()
 	for i := 0; i < len(formatList); i++ {
 		for j := 0; j < len(spotTrack.File); j++ {
-			switch i {
-			case 0:
-				if *spotTrack.File[j].Format == Spotify.AudioFile_OGG_VORBIS_320 {
-					formats = append(formats, formatList[i])
-					formats[len(formats)-1].File = spotTrack.File[j]
-					break
-				}
-			case 1:
-				if *spotTrack.File[j].Format == Spotify.AudioFile_OGG_VORBIS_320 {
-					formats = append(formats, formatList[i])
-					formats[len(formats)-1].File = spotTrack.File[j]
-					break
-				}
-			case 2:
-				if *spotTrack.File[j].Format == Spotify.AudioFile_OGG_VORBIS_320 {
-					formats = append(formats, formatList[i])
-					formats[len(formats)-1].File = spotTrack.File[j]
-					break
-				}
-			case 3:
-				if *spotTrack.File[j].Format == Spotify.AudioFile_OGG_VORBIS_320 {
-					formats = append(formats, formatList[i])
-					formats[len(formats)-1].File = spotTrack.File[j]
-					break
-				}
-			case 4:
-				if *spotTrack.File[j].Format == Spotify.AudioFile_OGG_VORBIS_320 {
-					formats = append(formats, formatList[i])
-					formats[len(formats)-1].File = spotTrack.File[j]
-					break
-				}
-			case 5:
-				if *spotTrack.File[j].Format == Spotify.AudioFile_OGG_VORBIS_320 {
-					formats = append(formats, formatList[i])
-					formats[len(formats)-1].File = spotTrack.File[j]
-					break
-				}
-			case 6:
-				if *spotTrack.File[j].Format == Spotify.AudioFile_OGG_VORBIS_320 {
-					formats = append(formats, formatList[i])
-					formats[len(formats)-1].File = spotTrack.File[j]
-					break
-				}
+			if spotTrack.File[j].Format == nil {
+				continue
+			}
+			formatID, ok := spotifyFormats[*spotTrack.File[j].Format]
+			if ok && formatID == formatList[i].ID {
+				formatList[i].File = spotTrack.File[j]
+				formats = append(formats, formatList[i])
+				break
 			}
 		}
 	}
@@ -409,50 +458,100 @@ func (s *SpotifyClient) Stream(trackID string) (stream *ObjectStream, err error)
 		Creators: creators,
 		Duration: int64(*spotTrack.Duration) / 1000,
 		Formats:  formats,
+		ISRC:       spotifyExternalID(spotTrack.ExternalId, "isrc"),
+		Popularity: int(spotTrack.GetPopularity()),
 	}
 	if spotTrack.Album != nil {
 		album := &Object{
 			URI:  "spotify:album:" + gid2Id(spotTrack.Album.Gid),
 			Type:     "album",
 			Provider: "spotify",
-			Object: &json.RawMessage{},
 		}
 		stream.Album = album
 		albumObj := &ObjectAlbum{
 			Name: *spotTrack.Album.Name,
 			URI:  "spotify:album:" + gid2Id(spotTrack.Album.Gid),
 		}
-		stream.Album.Object.UnmarshalJSON(albumObj.JSON())
+		stream.Album.Object = albumObj
 	}
 	return
 }
 
+// LookupISRC returns the URI of the Spotify track with the given ISRC
+func (s *SpotifyClient) LookupISRC(isrc string) (string, error) {
+	results, err := s.Search("isrc:" + isrc)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(results.Streams); i++ {
+		stream, err := s.Stream(strings.TrimPrefix(results.Streams[i].URI, "spotify:track:"))
+		if err == nil && strings.EqualFold(stream.ISRC, isrc) {
+			return stream.URI, nil
+		}
+	}
+	return "", NewError(ErrNotFound, "spotify", "spotify: no track with isrc %s", isrc)
+}
+
+// LookupUPC returns the URI of the Spotify album with the given UPC
+func (s *SpotifyClient) LookupUPC(upc string) (string, error) {
+	results, err := s.Search("upc:" + upc)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(results.Albums); i++ {
+		album, err := s.Album(strings.TrimPrefix(results.Albums[i].URI, "spotify:album:"))
+		if err == nil && normalizeUPC(album.UPC) == normalizeUPC(upc) {
+			return album.URI, nil
+		}
+	}
+	return "", NewError(ErrNotFound, "spotify", "spotify: no album with upc %s", upc)
+}
+
+// spotifyPopularity returns a search hit's popularity from 0 to 100, as hits may report it as a fraction
+func spotifyPopularity(popularity float32) int {
+	if popularity > 0 && popularity <= 1 {
+		popularity *= 100
+	}
+	return int(popularity)
+}
+
+// spotifyExternalID returns the external ID of the given type, ex: isrc or upc
+func spotifyExternalID(ids []*Spotify.ExternalId, typ string) string {
+	for i := 0; i < len(ids); i++ {
+		if ids[i].Typ != nil && ids[i].Id != nil && strings.EqualFold(*ids[i].Typ, typ) {
+			return *ids[i].Id
+		}
+	}
+	return ""
+}
+
 // Format gets a format object from a Spotify stream object
 func (s *SpotifyClient) StreamFormat(w http.ResponseWriter, r *http.Request, stream *ObjectStream, format int) (err error) {
 	objFormat := stream.GetFormat(format)
 	if objFormat == nil {
-		return fmt.Errorf("spotify: unknown format %d for stream %s", format, stream.ID)
+		return NewError(ErrNotFound, "spotify", "spotify: unknown format %d for stream %s", format, stream.ID)
 	}
 	file, ok := objFormat.File.(*Spotify.AudioFile)
 	if !ok || file == nil {
-		stream, err = s.Stream(stream.ID)
+		streamID := stream.ID
+		stream, err = s.Stream(streamID)
 		if err != nil {
-			return fmt.Errorf("spotify: failed to get stream %s: %v", stream.ID, err)
+			return WrapError(err, "spotify", "spotify: failed to get stream %s", streamID)
 		}
 		objFormat = stream.GetFormat(format)
 		if objFormat == nil {
-			return fmt.Errorf("spotify: unknown format %d for stream %s after resyncing", format, stream.ID)
+			return NewError(ErrNotFound, "spotify", "spotify: unknown format %d for stream %s after resyncing", format, stream.ID)
 		}
-		file = objFormat.File.(*Spotify.AudioFile)
-		if file == nil {
-			return fmt.Errorf("spotify: unknown file for format %d from stream %s after resyncing", format, stream.ID)
+		file, ok = objFormat.File.(*Spotify.AudioFile)
+		if !ok || file == nil {
+			return NewError(ErrNotFound, "spotify", "spotify: unknown file for format %d from stream %s after resyncing", format, stream.ID)
 		}
 	}
 	streamer, err := s.Session.Player().LoadTrackWithIdAndFormat(file.FileId, file.GetFormat(), id2Gid(stream.ID))
 	if err != nil {
-		return fmt.Errorf("spotify: failed to load track for stream %s: %v", stream.ID, err)
+		return NewError(ErrProviderUnavailable, "spotify", "spotify: failed to load track for stream %s: %v", stream.ID, err)
 	}
-	w.Header().Set("Content-Type", "audio/ogg")
+	w.Header().Set("Content-Type", spotifyContentType(objFormat))
 	http.ServeContent(w, r, stream.ID, time.Time{}, streamer)
 	return nil
 }
@@ -461,8 +560,8 @@ func (s *SpotifyClient) StreamFormat(w http.ResponseWriter, r *http.Request, str
 func (s *SpotifyClient) FormatList() (formats []*ObjectFormat) {
 	formats = []*ObjectFormat{
 		&ObjectFormat{
-			ID: 0,
-			Name: "Very High OGG",
+			ID:         0,
+			Name:       "Very High OGG",
 			Format:     "ogg",
 			Codec:      "vorbis",
 			BitRate:    320000,
@@ -470,8 +569,17 @@ func (s *SpotifyClient) FormatList() (formats []*ObjectFormat) {
 			SampleRate: 44100,
 		},
 		&ObjectFormat{
-			ID: 1,
-			Name: "Very High MP3",
+			ID:         7,
+			Name:       "Very High AAC",
+			Format:     "mp4",
+			Codec:      "aac",
+			BitRate:    320000,
+			BitDepth:   16,
+			SampleRate: 44100,
+		},
+		&ObjectFormat{
+			ID:         1,
+			Name:       "Very High MP3",
 			Format:     "mp3",
 			Codec:      "mp3",
 			BitRate:    320000,
@@ -479,8 +587,8 @@ func (s *SpotifyClient) FormatList() (formats []*ObjectFormat) {
 			SampleRate: 44100,
 		},
 		&ObjectFormat{
-			ID: 2,
-			Name: "High MP3",
+			ID:         2,
+			Name:       "High MP3",
 			Format:     "mp3",
 			Codec:      "mp3",
 			BitRate:    256000,
@@ -488,8 +596,8 @@ func (s *SpotifyClient) FormatList() (formats []*ObjectFormat) {
 			SampleRate: 44100,
 		},
 		&ObjectFormat{
-			ID: 3,
-			Name: "Normal OGG",
+			ID:         3,
+			Name:       "Normal OGG",
 			Format:     "ogg",
 			Codec:      "vorbis",
 			BitRate:    160000,
@@ -497,8 +605,17 @@ func (s *SpotifyClient) FormatList() (formats []*ObjectFormat) {
 			SampleRate: 44100,
 		},
 		&ObjectFormat{
-			ID: 4,
-			Name: "Normal MP3",
+			ID:         8,
+			Name:       "Normal AAC",
+			Format:     "mp4",
+			Codec:      "aac",
+			BitRate:    160000,
+			BitDepth:   16,
+			SampleRate: 44100,
+		},
+		&ObjectFormat{
+			ID:         4,
+			Name:       "Normal MP3",
 			Format:     "mp3",
 			Codec:      "mp3",
 			BitRate:    160000,
@@ -506,8 +623,17 @@ func (s *SpotifyClient) FormatList() (formats []*ObjectFormat) {
 			SampleRate: 44100,
 		},
 		&ObjectFormat{
-			ID: 5,
-			Name: "Low OGG",
+			ID:         9,
+			Name:       "Low AAC",
+			Format:     "mp4",
+			Codec:      "aac",
+			BitRate:    128000,
+			BitDepth:   16,
+			SampleRate: 44100,
+		},
+		&ObjectFormat{
+			ID:         5,
+			Name:       "Low OGG",
 			Format:     "ogg",
 			Codec:      "vorbis",
 			BitRate:    96000,
@@ -515,8 +641,8 @@ func (s *SpotifyClient) FormatList() (formats []*ObjectFormat) {
 			SampleRate: 44100,
 		},
 		&ObjectFormat{
-			ID: 6,
-			Name: "Low MP3",
+			ID:         6,
+			Name:       "Low MP3",
 			Format:     "mp3",
 			Codec:      "mp3",
 			BitRate:    96000,
@@ -527,6 +653,31 @@ func (s *SpotifyClient) FormatList() (formats []*ObjectFormat) {
 	return
 }
 
+// spotifyFormats maps each Spotify audio file format to the ID of its format template, IDs are kept stable as signed URLs and cached streams refer to them
+var spotifyFormats = map[Spotify.AudioFile_Format]int{
+	Spotify.AudioFile_OGG_VORBIS_320: 0,
+	Spotify.AudioFile_MP3_320:        1,
+	Spotify.AudioFile_MP3_256:        2,
+	Spotify.AudioFile_OGG_VORBIS_160: 3,
+	Spotify.AudioFile_MP3_160:        4,
+	Spotify.AudioFile_OGG_VORBIS_96:  5,
+	Spotify.AudioFile_MP3_96:         6,
+	Spotify.AudioFile_AAC_320:        7,
+	Spotify.AudioFile_AAC_160:        8,
+	Spotify.AudioFile_MP4_128:        9,
+}
+
+// spotifyContentType returns the MIME type of a format's container
+func spotifyContentType(format *ObjectFormat) string {
+	switch format.Format {
+	case "mp3":
+		return "audio/mpeg"
+	case "mp4":
+		return "audio/mp4"
+	}
+	return "audio/ogg"
+}
+
 // Search returns the results matching a given query
 func (s *SpotifyClient) Search(query string) (results *ObjectSearchResults, err error) {
 	s.Lock()
@@ -534,7 +685,7 @@ func (s *SpotifyClient) Search(query string) (results *ObjectSearchResults, err
 
 	searchResponse, err := s.Session.Mercury().Search(query, 10, s.Session.Country(), s.Session.Username())
 	if err != nil {
-		return nil, err
+		return nil, NewError(ErrProviderUnavailable, "spotify", "spotify: %v", err)
 	}
 
 	results = &ObjectSearchResults{}
@@ -546,8 +697,7 @@ func (s *SpotifyClient) Search(query string) (results *ObjectSearchResults, err
 				Name: artists[i].Name,
 				URI:  artists[i].Uri,
 			}
-			obj := &Object{URI: creator.URI, Type: "creator", Provider: "spotify", Object: &json.RawMessage{}}
-			obj.Object.UnmarshalJSON(creator.JSON())
+			obj := &Object{URI: creator.URI, Type: "creator", Provider: "spotify", Object: creator}
 
 			results.Creators = append(results.Creators, obj)
 		}
@@ -559,8 +709,12 @@ func (s *SpotifyClient) Search(query string) (results *ObjectSearchResults, err
 				Name: albums[i].Name,
 				URI:  albums[i].Uri,
 			}
-			obj := &Object{URI: album.URI, Type: "album", Provider: "spotify", Object: &json.RawMessage{}}
-			obj.Object.UnmarshalJSON(album.JSON())
+			for _, artist := range albums[i].Artists {
+				objCreator := &ObjectCreator{Name: artist.Name, URI: artist.Uri}
+				obj := &Object{URI: artist.Uri, Type: "creator", Provider: "spotify", Object: objCreator}
+				album.Creators = append(album.Creators, obj)
+			}
+			obj := &Object{URI: album.URI, Type: "album", Provider: "spotify", Object: album}
 
 			results.Albums = append(results.Albums, obj)
 		}
@@ -572,22 +726,21 @@ func (s *SpotifyClient) Search(query string) (results *ObjectSearchResults, err
 			stream.URI = tracks[i].Uri
 			for _, artist := range tracks[i].Artists {
 				objCreator := &ObjectCreator{Name: artist.Name, URI: artist.Uri}
-				obj := &Object{URI: artist.Uri, Type: "creator", Provider: "spotify", Object: &json.RawMessage{}}
-				obj.Object.UnmarshalJSON(objCreator.JSON())
+				obj := &Object{URI: artist.Uri, Type: "creator", Provider: "spotify", Object: objCreator}
 				stream.Creators = append(stream.Creators, obj)
 			}
-			stream.Album = &Object{URI: tracks[i].Album.Uri, Type: "album", Provider: "spotify", Object: &json.RawMessage{}}
+			stream.Album = &Object{URI: tracks[i].Album.Uri, Type: "album", Provider: "spotify"}
 			objAlbum := &ObjectAlbum{Name: tracks[i].Album.Name, URI: tracks[i].Album.Uri}
-			stream.Album.Object.UnmarshalJSON(objAlbum.JSON())
+			stream.Album.Object = objAlbum
 			stream.Artworks = []*ObjectArtwork{
 				&ObjectArtwork{
 					URL: tracks[i].Image,
 				},
 			}
 			stream.Duration = int64(tracks[i].Duration) / 1000
+			stream.Popularity = spotifyPopularity(tracks[i].Popularity)
 
-			objStream := &Object{URI: stream.URI, Type: "stream", Provider: "spotify", Object: &json.RawMessage{}}
-			objStream.Object.UnmarshalJSON(stream.JSON())
+			objStream := &Object{URI: stream.URI, Type: "stream", Provider: "spotify", Object: stream}
 			results.Streams = append(results.Streams, objStream)
 		}
 	}
@@ -631,46 +784,169 @@ func id2Gid(id string) (gid []byte) {
 }
 
 type SpotifyLyrics struct {
-	Colors *Colors
+	Colors          *Colors
 	HasVocalRemoval bool
-	Lyrics *SpotifyLyricsInner
+	Lyrics          *SpotifyLyricsInner
 }
 
 type Colors struct {
-	Background json.Number
+	Background    json.Number
 	HighlightText json.Number
-	Text json.Number
+	Text          json.Number
 }
 
 type SpotifyLyricsInner struct {
-	FullscreenAction string
-	IsDenseTypeface bool
-	IsRtlLanguage bool
-	Language string
-	Lines []*Line
-	Provider string
+	FullscreenAction    string
+	IsDenseTypeface     bool
+	IsRtlLanguage       bool
+	Language            string
+	Lines               []*Line
+	Provider            string
 	ProviderDisplayName string
-	ProviderLyricsID string
-	SyncLyricsURI string
-	SyncType json.Number //0=Unsynced,1=LineSynced
+	ProviderLyricsID    string
+	SyncLyricsURI       string
+	SyncType            json.RawMessage //0 or UNSYNCED, 1 or LINE_SYNCED
 }
 
 type Line struct {
 	StartTimeMs string
-	EndTimeMs string
-	Words string
-	Syllables []json.RawMessage
+	EndTimeMs   string
+	Words       string
+	Syllables   []*Syllable
 }
 
-func (s *SpotifyClient) Transcribe(stream *ObjectStream) (err error) {
+// Syllable holds the timing of a piece of a line, either with its own text or as a count of the line's characters
+type Syllable struct {
+	StartTimeMs json.Number
+	EndTimeMs   json.Number
+	NumChars    json.Number
+	Words       string
+}
+
+// Transcribe fills in the stream's transcript with its color-lyrics from Spotify, releasing the client once ctx is done
+func (s *SpotifyClient) Transcribe(ctx context.Context, stream *ObjectStream) (err error) {
 	s.Lock()
 	defer s.Unlock()
+	if err := ctx.Err(); err != nil {
+		return NewError(ErrProviderUnavailable, "spotify", "spotify: gave up on lyrics for stream %s: %v", stream.ID, err)
+	}
 
-	uri := fmt.Sprintf("hm://color-lyrics/v2/track/%s", stream.URI)
+	uri := fmt.Sprintf("hm://color-lyrics/v2/track/%s", stream.ID)
 	lyrics := &SpotifyLyrics{}
-	err = s.mercuryGetJson(uri, lyrics)
-	//Trace.Printf("Spotify Lyrics object: %v\n", lyrics)
-	return
+	err = s.mercuryGetJson(ctx, uri, lyrics)
+	if err != nil {
+		return err
+	}
+	if lyrics.Lyrics == nil || len(lyrics.Lyrics.Lines) == 0 {
+		return NewError(ErrNotFound, "spotify", "spotify: no lyrics for stream %s", stream.ID)
+	}
+
+	inner := lyrics.Lyrics
+	objTranscript := &ObjectTranscript{
+		RightToLeft:      inner.IsRtlLanguage,
+		Language:         inner.Language,
+		ProviderName:     inner.ProviderDisplayName,
+		ProviderLyricsID: inner.ProviderLyricsID,
+		ProviderTrackID:  inner.SyncLyricsURI,
+		SyncType:         spotifySyncType(inner.SyncType),
+		Lines:            make([]*ObjectTranscriptLine, 0, len(inner.Lines)),
+		Extra:            make(map[string]string),
+	}
+	objTranscript.TimeSynced = objTranscript.SyncType != TranscriptUnsynced
+	for i := 0; i < len(inner.Lines); i++ {
+		line := &ObjectTranscriptLine{Text: inner.Lines[i].Words}
+		if objTranscript.TimeSynced {
+			line.StartTimeMs, _ = strconv.Atoi(inner.Lines[i].StartTimeMs)
+			line.EndTimeMs, _ = strconv.Atoi(inner.Lines[i].EndTimeMs)
+			line.Segments = spotifySyllables(inner.Lines[i])
+			transcriptEnds(line)
+		}
+		objTranscript.Lines = append(objTranscript.Lines, line)
+	}
+	if objTranscript.SyncType == TranscriptSyllableSynced {
+		for i := 0; i < len(objTranscript.Lines) && objTranscript.SyncType == TranscriptSyllableSynced; i++ {
+			if len(objTranscript.Lines[i].Segments) == 0 && strings.TrimSpace(objTranscript.Lines[i].Text) != "" {
+				objTranscript.SyncType = TranscriptLineSynced //Syllables were promised but not given
+			}
+		}
+	}
+
+	if lyrics.Colors != nil {
+		for key, color := range map[string]json.Number{
+			"backgroundColor":    lyrics.Colors.Background,
+			"highlightTextColor": lyrics.Colors.HighlightText,
+			"textColor":          lyrics.Colors.Text,
+		} {
+			if hex := spotifyColor(color); hex != "" {
+				objTranscript.Extra[key] = hex
+			}
+		}
+	}
+	objTranscript.Extra["hasVocalRemoval"] = strconv.FormatBool(lyrics.HasVocalRemoval)
+	if inner.IsDenseTypeface {
+		objTranscript.Extra["denseTypeface"] = "true"
+	}
+
+	stream.Transcript = objTranscript
+	return nil
+}
+
+// spotifySyncType returns how Spotify timed a set of lyrics, which may be given as a number or a name
+func spotifySyncType(syncType json.RawMessage) string {
+	switch strings.Trim(string(syncType), "\"") {
+	case "", "0", "UNSYNCED":
+		return TranscriptUnsynced
+	case "2", "SYLLABLE_SYNCED":
+		return TranscriptSyllableSynced
+	}
+	return TranscriptLineSynced
+}
+
+// spotifySyllables returns the timed syllables of a line, taking each syllable's text either from itself or from the next characters of the line
+func spotifySyllables(line *Line) []*ObjectTranscriptSegment {
+	if len(line.Syllables) == 0 {
+		return nil
+	}
+	chars := []rune(line.Words)
+	pos := 0
+	segments := make([]*ObjectTranscriptSegment, 0, len(line.Syllables))
+	for i := 0; i < len(line.Syllables); i++ {
+		syllable := line.Syllables[i]
+		startTimeMs, err := syllable.StartTimeMs.Int64()
+		if err != nil {
+			return nil
+		}
+		endTimeMs, _ := syllable.EndTimeMs.Int64()
+		text := syllable.Words
+		if text == "" {
+			numChars, err := syllable.NumChars.Int64()
+			if err != nil || numChars <= 0 || pos+int(numChars) > len(chars) {
+				return nil
+			}
+			text = string(chars[pos : pos+int(numChars)])
+			pos += int(numChars)
+			//Whitespace between syllables ends a word, so it stays with the syllable before it
+			for pos < len(chars) && unicode.IsSpace(chars[pos]) {
+				text += string(chars[pos])
+				pos++
+			}
+		}
+		segments = append(segments, &ObjectTranscriptSegment{
+			StartTimeMs: int(startTimeMs),
+			EndTimeMs:   int(endTimeMs),
+			Text:        text,
+		})
+	}
+	return segments
+}
+
+// spotifyColor returns a signed ARGB color from Spotify as a hex RGB color, ex: #1db954
+func spotifyColor(color json.Number) string {
+	argb, err := color.Int64()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("#%06x", uint32(argb)&0xffffff)
 }
 
 // ReplaceURI replaces all instances of a URI with a libremedia-acceptable URI
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statsPath            = "stats.json"     //Where listen and download counts are stored
+	statsListenThreshold = 30               //How many seconds of a stream must be served to a session before it counts as a listen
+	statsSessionTimeout  = time.Minute * 30 //How long a session lasts after its last request
+	statsChartItems      = 100              //The maximum number of entries in a chart
+	statsDayFormat       = "2006-01-02"     //The format of each daily count
+	statsKeepDays        = 7                //How many days of daily counts to keep, the longest window other than all
+	statsSaveInterval    = time.Minute      //How often changed counts are written to disk
+)
+
+var (
+	//The windows of time a chart may be counted over, in days, where 0 is all time
+	statsWindows = map[string]int{
+		"day":  1,
+		"week": 7,
+		"all":  0,
+	}
+
+	stats = &Stats{
+		Streams:   make(map[string]*StatsCounter),
+		Downloads: make(map[string]*StatsCounter),
+		sessions:  make(map[string]*statsSession),
+	}
+)
+
+// Stats holds anonymous listen and download counts for every stream
+type Stats struct {
+	sync.Mutex
+
+	Streams   map[string]*StatsCounter `json:"streams"`   //Listens by stream URI
+	Downloads map[string]*StatsCounter `json:"downloads"` //Downloads by stream URI
+
+	sessions   map[string]*statsSession //Sessions by anonymous client and stream, never stored
+	dirty      bool                     //Whether or not the counts changed since they were last saved
+	loadFailed bool                     //Whether or not the stored counts failed to load, so they're never overwritten
+}
+
+// StatsCounter holds how many times a stream was counted, in total and on each recent day
+type StatsCounter struct {
+	Total int64            `json:"total"`
+	Days  map[string]int64 `json:"days,omitempty"`
+}
+
+// statsSession holds how much of a stream one client was served, so a listen is counted only once however many requests it takes
+type statsSession struct {
+	Served   int64     //How many bytes of the stream were served
+	Started  time.Time //When the first request arrived
+	LastSeen time.Time //When the last request arrived
+	Counted  bool      //Whether or not this session was already counted
+}
+
+// LoadStats reads the stored counts into memory, starting from nothing if there aren't any
+// If the stored counts can't be read, counting carries on in memory but nothing is saved, so the file is left for an admin to recover
+func LoadStats() error {
+	statsData, err := ioutil.ReadFile(statsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		stats.Lock()
+		stats.loadFailed = true
+		stats.Unlock()
+		return err
+	}
+	stats.Lock()
+	defer stats.Unlock()
+	if err := json.Unmarshal(statsData, stats); err != nil {
+		stats.loadFailed = true
+		return err
+	}
+	if stats.Streams == nil {
+		stats.Streams = make(map[string]*StatsCounter)
+	}
+	if stats.Downloads == nil {
+		stats.Downloads = make(map[string]*StatsCounter)
+	}
+	return nil
+}
+
+// Save writes the counts to disk if they changed since they were last saved
+func (s *Stats) Save() {
+	s.Lock()
+	if !s.dirty || s.loadFailed {
+		s.Unlock()
+		return
+	}
+	statsData, err := json.Marshal(s)
+	s.dirty = false
+	s.Unlock()
+	if err != nil {
+		Error.Printf("Failed to encode stats: %v\n", err)
+		return
+	}
+	if err := writeFileAtomic(statsPath, statsData, 0644); err != nil {
+		Error.Printf("Failed to save stats: %v\n", err)
+		s.Lock()
+		s.dirty = true
+		s.Unlock()
+	}
+}
+
+// MonitorStats saves the counts at the save interval whenever they changed
+func (s *Stats) MonitorStats() {
+	for {
+		time.Sleep(statsSaveInterval)
+		s.Save()
+	}
+}
+
+// count adds one to a stream's counter for today, dropping days older than the longest window
+func (s *Stats) count(counters map[string]*StatsCounter, uri string) {
+	counter, exists := counters[uri]
+	if !exists {
+		counter = &StatsCounter{}
+		counters[uri] = counter
+	}
+	if counter.Days == nil {
+		counter.Days = make(map[string]int64)
+	}
+	now := time.Now().UTC()
+	counter.Total++
+	counter.Days[now.Format(statsDayFormat)]++
+	oldest := now.AddDate(0, 0, -statsKeepDays).Format(statsDayFormat)
+	for day := range counter.Days {
+		if day <= oldest {
+			delete(counter.Days, day)
+		}
+	}
+	s.dirty = true
+}
+
+// session returns the session of a client for a stream, starting a new one if it timed out, the caller must hold the lock
+func (s *Stats) session(key string) *statsSession {
+	now := time.Now()
+	for sessionKey, session := range s.sessions {
+		if now.Sub(session.LastSeen) > statsSessionTimeout {
+			delete(s.sessions, sessionKey)
+		}
+	}
+	session, exists := s.sessions[key]
+	if !exists {
+		session = &statsSession{Started: now}
+		s.sessions[key] = session
+	}
+	session.LastSeen = now
+	return session
+}
+
+// Served records that bytes of a stream were served to a user, counting a listen once the session passes the listen threshold
+func (s *Stats) Served(r *http.Request, user *ServiceUser, stream *ObjectStream, served, size int64) {
+	if stream == nil || stream.URI == "" || served <= 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	session := s.session(statsSessionKey(r, user, "stream", stream.URI))
+	session.Served += served
+	if session.Counted {
+		return
+	}
+
+	//Count once enough of the stream was served to cover the threshold, or half of it for short streams
+	if size > 0 {
+		needed := size / 2
+		if stream.Duration > statsListenThreshold {
+			needed = size * statsListenThreshold / stream.Duration
+		}
+		if session.Served < needed {
+			return
+		}
+	} else if time.Since(session.Started) < time.Second*statsListenThreshold {
+		return //Without a size, fall back to how long the session has been requesting the stream
+	}
+	session.Counted = true
+	s.count(s.Streams, stream.URI)
+}
+
+// Downloaded counts a download of a stream, once per session
+func (s *Stats) Downloaded(r *http.Request, user *ServiceUser, stream *ObjectStream) {
+	if stream == nil || stream.URI == "" {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	session := s.session(statsSessionKey(r, user, "download", stream.URI))
+	if session.Counted {
+		return
+	}
+	session.Counted = true
+	s.count(s.Downloads, stream.URI)
+}
+
+// Chart returns the URIs and counts of the most counted streams within a window, from most to least counted
+func (s *Stats) Chart(chart, window string) ([]*ObjectChartEntry, error) {
+	days, exists := statsWindows[window]
+	if !exists {
+		return nil, NewError(ErrBadURI, "", "charts: no window %s, try day, week or all", window)
+	}
+	s.Lock()
+	defer s.Unlock()
+	counters := s.Streams
+	switch chart {
+	case "streams":
+	case "downloads":
+		counters = s.Downloads
+	default:
+		return nil, NewError(ErrNotFound, "", "charts: no chart %s, try streams or downloads", chart)
+	}
+
+	oldest := ""
+	if days > 0 {
+		oldest = time.Now().UTC().AddDate(0, 0, -days).Format(statsDayFormat)
+	}
+	entries := make([]*ObjectChartEntry, 0)
+	for uri, counter := range counters {
+		count := counter.Total
+		if days > 0 {
+			count = 0
+			for day, dayCount := range counter.Days {
+				if day > oldest {
+					count += dayCount
+				}
+			}
+		}
+		if count > 0 {
+			entries = append(entries, &ObjectChartEntry{Count: count, Object: &Object{URI: uri, Type: "stream"}})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count == entries[j].Count {
+			return entries[i].Object.URI < entries[j].Object.URI
+		}
+		return entries[i].Count > entries[j].Count
+	})
+	if len(entries) > statsChartItems {
+		entries = entries[:statsChartItems]
+	}
+	return entries, nil
+}
+
+// statsSessionKey returns an anonymous key for a user's session with a stream, so no address is ever kept
+func statsSessionKey(r *http.Request, user *ServiceUser, kind, uri string) string {
+	//Guests share one user, so only their address and client tell them apart
+	client := "user\n"
+	if user != nil && user.ID != "guest" {
+		client += user.ID
+	} else {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		client = "client\n" + host + "\n" + r.UserAgent()
+	}
+	sum := sha256.Sum256([]byte(kind + "\n" + uri + "\n" + client))
+	return hex.EncodeToString(sum[:])
+}
+
+// statsWriter counts the bytes served to a client and reads the full size of the stream from the response headers
+type statsWriter struct {
+	http.ResponseWriter
+	served int64
+	size   int64
+}
+
+func (w *statsWriter) WriteHeader(statusCode int) {
+	header := w.Header()
+	if contentRange := header.Get("Content-Range"); contentRange != "" {
+		//ex: bytes 0-1023/4096
+		if i := strings.LastIndex(contentRange, "/"); i > -1 {
+			w.size, _ = strconv.ParseInt(contentRange[i+1:], 10, 64)
+		}
+	} else if statusCode == http.StatusOK {
+		w.size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statsWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.served += int64(n)
+	return n, err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestStatsSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libremedia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	saved := stats
+	defer func() { stats = saved }()
+
+	//Counts are only written when saved, and only if they changed
+	stats = &Stats{Streams: map[string]*StatsCounter{}, Downloads: map[string]*StatsCounter{}, sessions: map[string]*statsSession{}}
+	stats.Downloaded(httptest.NewRequest("GET", "/v1/download/tidal:track:1", nil), nil, &ObjectStream{URI: "tidal:track:1"})
+	if _, err := os.Stat(statsPath); !os.IsNotExist(err) {
+		t.Fatalf("stats were written on every count, want them written when saved")
+	}
+	stats.Save()
+	if err := LoadStats(); err != nil || stats.Downloads["tidal:track:1"] == nil || stats.Downloads["tidal:track:1"].Total != 1 {
+		t.Fatalf("saved stats didn't load back: %v", err)
+	}
+
+	//A file that fails to load is never overwritten
+	corrupt := []byte(`{"streams":{"tidal:track:1":{"total":`)
+	ioutil.WriteFile(statsPath, corrupt, 0644)
+	stats = &Stats{Streams: map[string]*StatsCounter{}, Downloads: map[string]*StatsCounter{}, sessions: map[string]*statsSession{}}
+	if err := LoadStats(); err == nil {
+		t.Fatalf("loaded corrupt stats, want error")
+	}
+	stats.Downloaded(httptest.NewRequest("GET", "/v1/download/tidal:track:2", nil), nil, &ObjectStream{URI: "tidal:track:2"})
+	stats.Save()
+	if data, _ := ioutil.ReadFile(statsPath); string(data) != string(corrupt) {
+		t.Errorf("stats that failed to load were overwritten with %s", data)
+	}
+	if entries, _ := stats.Chart("downloads", "all"); len(entries) != 1 {
+		t.Errorf("counts after a failed load = %d entries, want them kept in memory", len(entries))
+	}
+}
+
+func TestStatsSessionKey(t *testing.T) {
+	request := func(addr, agent string) *http.Request {
+		r := httptest.NewRequest("GET", "/v1/stream/tidal:track:1", nil)
+		r.RemoteAddr = addr
+		r.Header.Set("User-Agent", agent)
+		return r
+	}
+	alice := &ServiceUser{ID: userID("alice")}
+	guest := &ServiceUser{ID: "guest"}
+	tests := []struct {
+		name   string
+		a, b   *http.Request
+		ua, ub *ServiceUser
+		same   bool
+	}{
+		{"user on another device", request("10.0.0.1:1000", "phone"), request("10.0.0.2:2000", "laptop"), alice, alice, true},
+		{"users sharing an address", request("10.0.0.1:1000", "phone"), request("10.0.0.1:1000", "phone"), alice, &ServiceUser{ID: userID("bob")}, false},
+		{"guest on another port", request("10.0.0.1:1000", "phone"), request("10.0.0.1:2000", "phone"), guest, guest, true},
+		{"guests on different clients", request("10.0.0.1:1000", "phone"), request("10.0.0.1:1000", "laptop"), guest, guest, false},
+		{"signed without a user", request("10.0.0.1:1000", "phone"), request("10.0.0.2:1000", "phone"), nil, nil, false},
+	}
+	for _, test := range tests {
+		a := statsSessionKey(test.a, test.ua, "stream", "tidal:track:1")
+		b := statsSessionKey(test.b, test.ub, "stream", "tidal:track:1")
+		if (a == b) != test.same {
+			t.Errorf("%s: same session = %v, want %v", test.name, a == b, test.same)
+		}
+	}
+}
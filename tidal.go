@@ -61,9 +61,29 @@ type TidalError struct {
 	ErrorMsg  string `json:"error_description"`
 }
 
-// Error returns an error
-func (terr *TidalError) Error() error {
-	return fmt.Errorf("%d:%d %s: %s", terr.Status, terr.SubStatus, terr.ErrorType, terr.ErrorMsg)
+// Error returns the message of this error
+func (terr *TidalError) Error() string {
+	return fmt.Sprintf("%d:%d %s: %s", terr.Status, terr.SubStatus, terr.ErrorType, terr.ErrorMsg)
+}
+
+// ObjectError maps this error onto a libremedia error code
+func (terr *TidalError) ObjectError() *ObjectError {
+	code := ErrInternal
+	switch {
+	case terr.Status == 429:
+		code = ErrRateLimited
+	case terr.Status == 404:
+		code = ErrNotFound
+	case terr.Status == 400:
+		code = ErrBadURI
+	case terr.SubStatus == 4005 || terr.SubStatus == 4032 || terr.SubStatus == 4035: //Asset not ready for playback, or not available in the user's region
+		code = ErrRegionRestricted
+	case terr.Status == 401 || terr.Status == 403:
+		code = ErrUnauthorized
+	case terr.Status >= 500:
+		code = ErrProviderUnavailable
+	}
+	return NewError(code, "tidal", "tidal: %s", terr.Error())
 }
 
 // TidalClient holds a Tidal client
@@ -83,6 +103,19 @@ func (t *TidalClient) Provider() string {
 	return "tidal"
 }
 
+// About returns the display name, icon and capabilities of Tidal
+func (t *TidalClient) About() *ObjectProvider {
+	return &ObjectProvider{
+		Provider:      t.Provider(),
+		Name:          "TIDAL",
+		Icon:          "https://tidal.com/favicon.ico",
+		Types:         []string{"creator", "album", "stream"},
+		Formats:       t.FormatList(),
+		Transcribes:   true,
+		Authenticated: !t.NeedsAuth(),
+	}
+}
+
 // SetService sets the global libremedia service for this provider
 func (t *TidalClient) SetService(service *Service) {
 	t.Service = service
@@ -128,8 +161,21 @@ func (t *TidalClient) Authenticate(cfg *HandlerConfig) (handler Handler, err err
 	return t, nil
 }
 
+// Health checks that the Tidal session is still authenticated and reachable
+func (t *TidalClient) Health() error {
+	if t.NeedsAuth() {
+		return fmt.Errorf("tidal: session needs authenticating")
+	}
+	return t.GetJSON("sessions", nil, nil)
+}
+
 // Get attempts to roundtrip an authenticated request to Tidal
 func (t *TidalClient) Get(endpoint string, query url.Values) (*http.Response, error) {
+	return t.GetContext(context.Background(), endpoint, query)
+}
+
+// GetContext gets an authenticated resource from a Tidal endpoint, giving up once ctx is done
+func (t *TidalClient) GetContext(ctx context.Context, endpoint string, query url.Values) (*http.Response, error) {
 	t.Lock()
 	defer t.Unlock()
 
@@ -137,7 +183,7 @@ func (t *TidalClient) Get(endpoint string, query url.Values) (*http.Response, er
 		query = url.Values{}
 	}
 	query.Add("countryCode", t.Auth.CountryCode)
-	req, err := http.NewRequest("GET", tidalAPI+endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", tidalAPI+endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -151,17 +197,27 @@ func (t *TidalClient) Get(endpoint string, query url.Values) (*http.Response, er
 
 // GetJSON gets an authenticated JSON resource from a Tidal endpoint and writes it to a target interface
 func (t *TidalClient) GetJSON(endpoint string, query url.Values, target interface{}) error {
-	resp, err := t.Get(endpoint, query)
+	return t.GetJSONContext(context.Background(), endpoint, query, target)
+}
+
+// GetJSONContext gets an authenticated JSON resource from a Tidal endpoint and writes it to a target interface, giving up once ctx is done
+func (t *TidalClient) GetJSONContext(ctx context.Context, endpoint string, query url.Values, target interface{}) error {
+	resp, err := t.GetContext(ctx, endpoint, query)
 	if err != nil {
-		return err
+		return NewError(ErrProviderUnavailable, "tidal", "tidal: %v", err)
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return NewError(ErrProviderUnavailable, "tidal", "tidal: %v", err)
 	}
 	//Trace.Println("Tidal:", resp.Status, endpoint, "\n", string(body))
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("%s: %s", resp.Status, string(body))
+		terr := &TidalError{}
+		if err := json.Unmarshal(body, terr); err != nil || terr.Status == 0 {
+			terr = &TidalError{Status: resp.StatusCode, ErrorType: resp.Status, ErrorMsg: string(body)}
+		}
+		return terr.ObjectError()
 	}
 	if target != nil {
 		return json.Unmarshal(body, target)
@@ -177,6 +233,7 @@ type TidalArtist struct {
 	Albums        []TidalAlbum   `json:"albums,omitempty"`
 	EPsAndSingles []TidalAlbum   `json:"epsandsingles,omitempty"`
 	Picture       string         `json:"picture,omitempty"`
+	Popularity    int            `json:"popularity,omitempty"`
 }
 
 // TidalArtistAlbums holds a Tidal artist's album list
@@ -232,9 +289,8 @@ func (t *TidalClient) Creator(creatorID string) (creator *ObjectCreator, err err
 				URI:      "tidal:artist:" + tTrack.Artists[j].ID.String(),
 				Type:     "creator",
 				Provider: "tidal",
-				Object:   &jsontwo.RawMessage{},
+				Object:   objCreator,
 			}
-			tCreators[j].Object.UnmarshalJSON(objCreator.JSON())
 		}
 		trackNum, err := tTrack.TrackNumber.Int64()
 		if err != nil {
@@ -250,26 +306,25 @@ func (t *TidalClient) Creator(creatorID string) (creator *ObjectCreator, err err
 			Name:     tTopTracks.Items[i].Title,
 			Track:    int(trackNum),
 			Duration: duration,
+			ISRC:     tTrack.ISRC,
 			Creators: tCreators,
 			Album: &Object{
 				URI:      "tidal:album:" + tTrack.Album.ID.String(),
 				Type:     "album",
 				Provider: "tidal",
-				Object:   &jsontwo.RawMessage{},
 			},
 		}
 		objAlbum := &ObjectAlbum{
 			URI:  "tidal:album:" + tTrack.Album.ID.String(),
 			Name: tTrack.Album.Title,
 		}
-		objStream.Album.Object.UnmarshalJSON(objAlbum.JSON())
+		objStream.Album.Object = objAlbum
 		topTracks[i] = &Object{
 			URI:      "tidal:track:" + tTopTracks.Items[i].ID.String(),
 			Type:     "stream",
 			Provider: "tidal",
-			Object:   &jsontwo.RawMessage{},
+			Object:   objStream,
 		}
-		topTracks[i].Object.UnmarshalJSON(objStream.JSON())
 	}
 	tAlbums := TidalArtistAlbums{}
 	albumFilter := url.Values{}
@@ -292,9 +347,8 @@ func (t *TidalClient) Creator(creatorID string) (creator *ObjectCreator, err err
 			URI:      "tidal:album:" + tAlbum.ID.String(),
 			Type:     "album",
 			Provider: "tidal",
-			Object:   &jsontwo.RawMessage{},
+			Object:   objAlbum,
 		}
-		albums[i].Object.UnmarshalJSON(objAlbum.JSON())
 	}
 	epsandsingles := TidalArtistAlbums{}
 	albumFilter.Set("filter", "EPSANDSINGLES")
@@ -316,9 +370,8 @@ func (t *TidalClient) Creator(creatorID string) (creator *ObjectCreator, err err
 			URI:      "tidal:album:" + tSingle.ID.String(),
 			Type:     "album",
 			Provider: "tidal",
-			Object:   &jsontwo.RawMessage{},
+			Object:   objAlbum,
 		}
-		singles[i].Object.UnmarshalJSON(objAlbum.JSON())
 	}
 	creator = &ObjectCreator{
 		URI:         "tidal:artist:" + creatorID,
@@ -347,7 +400,9 @@ type TidalAlbum struct {
 	AudioModes      []string       `json:"audioModes,omitempty"` //usually just STEREO
 	Artists         []TidalArtist  `json:"artists,omitempty"`
 	Tracks          []TidalTrack   `json:"tracks,omitempty"`
-	Cover           string         `json:"cover,omitempty"`      //An image cover for the album
+	Cover           string         `json:"cover,omitempty"` //An image cover for the album
+	UPC             string         `json:"upc,omitempty"`   //The Universal Product Code of the album
+	Popularity      int            `json:"popularity,omitempty"`
 	VideoCover      string         `json:"videoCover,omitempty"` //A video cover for the album
 }
 
@@ -378,9 +433,8 @@ func (t *TidalClient) Album(albumID string) (album *ObjectAlbum, err error) {
 			URI:      "tidal:artist:" + tAlbum.Artists[i].ID.String(),
 			Type:     "creator",
 			Provider: "tidal",
-			Object:   &jsontwo.RawMessage{},
+			Object:   objCreator,
 		}
-		creators[i].Object.UnmarshalJSON(objCreator.JSON())
 	}
 	tracks := TidalAlbumTracks{}
 	albumFilter := url.Values{}
@@ -413,9 +467,8 @@ func (t *TidalClient) Album(albumID string) (album *ObjectAlbum, err error) {
 				URI:      "tidal:artist:" + tTrack.Artists[j].ID.String(),
 				Type:     "creator",
 				Provider: "tidal",
-				Object:   &jsontwo.RawMessage{},
+				Object:   objCreator,
 			}
-			tCreators[j].Object.UnmarshalJSON(objCreator.JSON())
 		}
 		trackNum, err := tTrack.TrackNumber.Int64()
 		if err != nil {
@@ -431,27 +484,26 @@ func (t *TidalClient) Album(albumID string) (album *ObjectAlbum, err error) {
 			Name:     tTrack.Title,
 			Track:    int(trackNum),
 			Duration: duration,
+			ISRC:     tTrack.ISRC,
 			Creators: tCreators,
 			Album: &Object{
 				URI:      "tidal:album:" + albumID,
 				Type:     "album",
 				Provider: "tidal",
-				Object:   &jsontwo.RawMessage{},
 			},
 		}
 		objAlbum := &ObjectAlbum{
 			URI:      "tidal:album:" + albumID,
 			Name:     tAlbum.Title,
-			Creators: creators,
+			Creators: append([]*Object{}, creators...), //Expanding the album shouldn't expand it within every stream
 		}
-		objStream.Album.Object.UnmarshalJSON(objAlbum.JSON())
+		objStream.Album.Object = objAlbum
 		discs[0].Streams[i] = &Object{
 			URI:      "tidal:track:" + tTrack.ID.String(),
 			Type:     "stream",
 			Provider: "tidal",
-			Object:   &jsontwo.RawMessage{},
+			Object:   objStream,
 		}
-		discs[0].Streams[i].Object.UnmarshalJSON(objStream.JSON())
 	}
 	album = &ObjectAlbum{
 		URI:        "tidal:album:" + albumID,
@@ -463,6 +515,7 @@ func (t *TidalClient) Album(albumID string) (album *ObjectAlbum, err error) {
 		Artworks:   make([]*ObjectArtwork, 0),
 		DateTime:   tAlbum.ReleaseDate,
 		Explicit:   tAlbum.Explicit,
+		UPC:        tAlbum.UPC,
 	}
 	album.Artworks = append(album.Artworks, t.ArtworkImg(tAlbum.Cover, tidalSizesAlbum)...)
 	album.Artworks = append(album.Artworks, t.ArtworkVid(tAlbum.VideoCover, tidalSizesAlbum)...)
@@ -520,9 +573,8 @@ func (t *TidalClient) Stream(trackID string) (stream *ObjectStream, err error) {
 			URI:      "tidal:artist:" + tTrack.Artists[i].ID.String(),
 			Type:     "creator",
 			Provider: "tidal",
-			Object:   &jsontwo.RawMessage{},
+			Object:   objCreator,
 		}
-		creators[i].Object.UnmarshalJSON(objCreator.JSON())
 	}
 	formats := make([]*ObjectFormat, 0)
 	formatList := t.FormatList()
@@ -543,20 +595,60 @@ func (t *TidalClient) Stream(trackID string) (stream *ObjectStream, err error) {
 			URI:      "tidal:album:" + tTrack.Album.ID.String(),
 			Type:     "album",
 			Provider: "tidal",
-			Object:   &jsontwo.RawMessage{},
 		},
 		Explicit: tTrack.Explicit,
 		Duration: duration,
 		Formats:  formats,
+		ISRC:     tTrack.ISRC,
 	}
 	objAlbum := &ObjectAlbum{
 		URI:  "tidal:album:" + tTrack.Album.ID.String(),
 		Name: tTrack.Album.Title,
 	}
-	stream.Album.Object.UnmarshalJSON(objAlbum.JSON())
+	stream.Album.Object = objAlbum
 	return
 }
 
+// TidalAlbums holds a Tidal album list
+type TidalAlbums struct {
+	Limit              int          `json:"limit"`
+	Offset             int          `json:"offset"`
+	TotalNumberOfItems int          `json:"totalNumberOfItems"`
+	Items              []TidalAlbum `json:"items"`
+}
+
+// LookupISRC returns the URI of the Tidal track with the given ISRC
+func (t *TidalClient) LookupISRC(isrc string) (string, error) {
+	tracks := TidalTracks{}
+	query := url.Values{}
+	query.Set("isrc", isrc)
+	if err := t.GetJSON("tracks", query, &tracks); err != nil {
+		return "", err
+	}
+	for i := 0; i < len(tracks.Items); i++ {
+		if strings.EqualFold(tracks.Items[i].ISRC, isrc) {
+			return "tidal:track:" + tracks.Items[i].ID.String(), nil
+		}
+	}
+	return "", NewError(ErrNotFound, "tidal", "tidal: no track with isrc %s", isrc)
+}
+
+// LookupUPC returns the URI of the Tidal album with the given UPC
+func (t *TidalClient) LookupUPC(upc string) (string, error) {
+	albums := TidalAlbums{}
+	query := url.Values{}
+	query.Set("upc", upc)
+	if err := t.GetJSON("albums", query, &albums); err != nil {
+		return "", err
+	}
+	for i := 0; i < len(albums.Items); i++ {
+		if normalizeUPC(albums.Items[i].UPC) == normalizeUPC(upc) {
+			return "tidal:album:" + albums.Items[i].ID.String(), nil
+		}
+	}
+	return "", NewError(ErrNotFound, "tidal", "tidal: no album with upc %s", upc)
+}
+
 // TidalVideo holds a Tidal video
 type TidalVideo struct {
 	Title    string         `json:"title"`
@@ -635,14 +727,14 @@ type TidalLyrics struct {
 	Subtitles        string         `json:"subtitles"`
 }
 
-// Transcribe fills in a lyrics object from Tidal
-func (t *TidalClient) Transcribe(stream *ObjectStream) (err error) {
+// Transcribe fills in a lyrics object from Tidal, giving up once ctx is done
+func (t *TidalClient) Transcribe(ctx context.Context, stream *ObjectStream) (err error) {
 	lyrics := &TidalLyrics{}
 	uri := fmt.Sprintf("tracks/%s/lyrics", stream.ID)
 	reqForm := url.Values{}
 	reqForm.Set("deviceType", "BROWSER")
 	reqForm.Set("locale", "en_US")
-	err = t.GetJSON(uri, reqForm, &lyrics)
+	err = t.GetJSONContext(ctx, uri, reqForm, &lyrics)
 	if err != nil {
 		return
 	}
@@ -657,31 +749,12 @@ func (t *TidalClient) Transcribe(stream *ObjectStream) (err error) {
 		}
 	}
 
-	lines := strings.Split(text, "\n")
-	objTranscript := &ObjectTranscript{
-		RightToLeft:      lyrics.RightToLeft,
-		Provider:         lyrics.Provider,
-		ProviderLyricsID: lyrics.ProviderLyricsID.String(),
-		ProviderTrackID:  lyrics.ProviderTrackID.String(),
-		TimeSynced:       false,
-		Lines:            make([]*ObjectTranscriptLine, 0),
-	}
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-		var min, sec, ms = 0, 0, 0
-		n, _ := fmt.Sscanf(line, "[%d:%d.%d]", &min, &sec, &ms)
-		if n == 3 {
-			objTranscript.TimeSynced = true
-			startTimeMs := (min * 60 * 1000) + (sec * 1000) + (ms * 10)
-			txt := strings.Split(line, "] ")[1]
-			objTranscript.Lines = append(objTranscript.Lines, &ObjectTranscriptLine{
-				StartTimeMs: startTimeMs,
-				Text:        txt,
-			})
-		} else {
-			objTranscript.Lines = append(objTranscript.Lines, &ObjectTranscriptLine{Text: line})
-		}
-	}
+	//Subtitles are LRC, and may time each word too
+	objTranscript := parseLRC(text)
+	objTranscript.RightToLeft = lyrics.RightToLeft
+	objTranscript.ProviderName = lyrics.Provider
+	objTranscript.ProviderLyricsID = lyrics.ProviderLyricsID.String()
+	objTranscript.ProviderTrackID = lyrics.ProviderTrackID.String()
 	if objTranscript.TimeSynced {
 		Trace.Println("Tidal: Successfully time synced " + stream.URI)
 	} else {
@@ -696,11 +769,11 @@ func (t *TidalClient) Transcribe(stream *ObjectStream) (err error) {
 func (t *TidalClient) StreamFormat(w http.ResponseWriter, r *http.Request, stream *ObjectStream, format int) (err error) {
 	objFormat := stream.GetFormat(format)
 	if objFormat == nil {
-		return fmt.Errorf("tidal: unknown format %d for stream %s", format, stream.ID)
+		return NewError(ErrNotFound, "tidal", "tidal: unknown format %d for stream %s", format, stream.ID)
 	}
 	manifest, err := t.GetAudioStream(stream.ID, objFormat.Name)
 	if err != nil {
-		return fmt.Errorf("tidal: unable to retrieve audio stream for stream %s at %s quality", stream.ID, objFormat.ID)
+		return WrapError(err, "tidal", "tidal: unable to retrieve audio stream for stream %s at %s quality", stream.ID, objFormat.Name)
 	}
 	w.Header().Set("Content-Type", manifest.MimeType)
 	for i := 0; i < len(manifest.URLs); i++ {
@@ -900,8 +973,8 @@ func (t *TidalClient) FormatList() (formats []*ObjectFormat) {
 		{
 			ID:         2,
 			Name:       "HIGH",
-			Format:     "flac",
-			Codec:      "flac",
+			Format:     "mp4",
+			Codec:      "aac",
 			BitRate:    320000,
 			BitDepth:   16,
 			SampleRate: 44100,
@@ -909,8 +982,8 @@ func (t *TidalClient) FormatList() (formats []*ObjectFormat) {
 		{
 			ID:         3,
 			Name:       "LOW",
-			Format:     "flac",
-			Codec:      "flac",
+			Format:     "mp4",
+			Codec:      "aac",
 			BitRate:    96000,
 			BitDepth:   16,
 			SampleRate: 44100,
@@ -1055,11 +1128,11 @@ func (t *TidalClient) Search(query string) (results *ObjectSearchResults, err er
 			artists := searchResults.Artists.Items
 			for i := 0; i < len(artists); i++ {
 				creator := &ObjectCreator{
-					Name: artists[i].Name,
-					URI:  "tidal:artist:" + artists[i].ID.String(),
+					Name:       artists[i].Name,
+					URI:        "tidal:artist:" + artists[i].ID.String(),
+					Popularity: artists[i].Popularity,
 				}
-				objCreator := &Object{URI: creator.URI, Type: "creator", Provider: "tidal", Object: &jsontwo.RawMessage{}}
-				objCreator.Object.UnmarshalJSON(creator.JSON())
+				objCreator := &Object{URI: creator.URI, Type: "creator", Provider: "tidal", Object: creator}
 				results.Creators = append(results.Creators, objCreator)
 			}
 		}
@@ -1067,34 +1140,38 @@ func (t *TidalClient) Search(query string) (results *ObjectSearchResults, err er
 			albums := searchResults.Albums.Items
 			for i := 0; i < len(albums); i++ {
 				album := &ObjectAlbum{
-					Name: albums[i].Title,
-					URI:  "tidal:album:" + albums[i].ID.String(),
+					Name:       albums[i].Title,
+					URI:        "tidal:album:" + albums[i].ID.String(),
+					UPC:        albums[i].UPC,
+					Popularity: albums[i].Popularity,
+				}
+				for _, artist := range albums[i].Artists {
+					objCreator := &ObjectCreator{Name: artist.Name, URI: "tidal:artist:" + artist.ID.String()}
+					obj := &Object{URI: objCreator.URI, Type: "creator", Provider: "tidal", Object: objCreator}
+					album.Creators = append(album.Creators, obj)
 				}
-				objAlbum := &Object{URI: album.URI, Type: "album", Provider: "tidal", Object: &jsontwo.RawMessage{}}
-				objAlbum.Object.UnmarshalJSON(album.JSON())
-				results.Albums = append(results.Creators, objAlbum)
+				objAlbum := &Object{URI: album.URI, Type: "album", Provider: "tidal", Object: album}
+				results.Albums = append(results.Albums, objAlbum)
 			}
 		}
 		if searchResults.Tracks.TotalNumberOfItems > 0 {
 			tracks := searchResults.Tracks.Items
 			for i := 0; i < len(tracks); i++ {
-				stream := &ObjectStream{Name: tracks[i].Title}
+				stream := &ObjectStream{Name: tracks[i].Title, ISRC: tracks[i].ISRC, Popularity: tracks[i].Popularity}
 				stream.URI = "tidal:track:" + tracks[i].ID.String()
 				for _, artist := range tracks[i].Artists {
 					objCreator := &ObjectCreator{Name: artist.Name, URI: "tidal:artist:" + artist.ID.String()}
-					obj := &Object{URI: "tidal:artist:" + artist.ID.String(), Type: "creator", Provider: "tidal", Object: &jsontwo.RawMessage{}}
-					obj.Object.UnmarshalJSON(objCreator.JSON())
+					obj := &Object{URI: "tidal:artist:" + artist.ID.String(), Type: "creator", Provider: "tidal", Object: objCreator}
 					stream.Creators = append(stream.Creators, obj)
 				}
-				stream.Album = &Object{URI: "tidal:album:" + tracks[i].Album.ID.String(), Type: "album", Provider: "tidal", Object: &jsontwo.RawMessage{}}
+				stream.Album = &Object{URI: "tidal:album:" + tracks[i].Album.ID.String(), Type: "album", Provider: "tidal"}
 				objAlbum := &ObjectAlbum{Name: tracks[i].Album.Title, URI: "tidal:album:" + tracks[i].Album.ID.String()}
-				stream.Album.Object.UnmarshalJSON(objAlbum.JSON())
+				stream.Album.Object = objAlbum
 				stream.Duration, err = tracks[i].Duration.Int64()
 				if err != nil {
 					return results, err
 				}
-				objStream := &Object{URI: stream.URI, Type: "stream", Provider: "tidal", Object: &jsontwo.RawMessage{}}
-				objStream.Object.UnmarshalJSON(stream.JSON())
+				objStream := &Object{URI: stream.URI, Type: "stream", Provider: "tidal", Object: stream}
 				results.Streams = append(results.Streams, objStream)
 			}
 		}
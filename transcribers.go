@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rhnvrm/lyric-api-go"
+)
+
+const (
+	transcriberTimeout = time.Second * 10 //How long each transcriber may take by default
+	sidecarPath        = "transcripts/"   //Where the sidecar transcriber looks for files by default
+)
+
+var (
+	//Built-in transcribers by name, more may be registered before the configuration is loaded
+	transcribers = map[string]func(cfg *TranscriberConfig) (Transcriber, error){
+		"source":  newSourceTranscriber,
+		"sidecar": newSidecarTranscriber,
+		"lyrics":  newLyricsTranscriber,
+	}
+	//The chain used when none is configured, which matches how transcripts were always found
+	defaultTranscribers = []*TranscriberConfig{{Name: "source"}, {Name: "lyrics"}}
+)
+
+// Transcriber finds transcripts for streams, like lyrics, closed captioning or subtitles
+type Transcriber interface {
+	Name() string                                                                    //Recorded as the provider of the transcripts it finds, unless it names one itself
+	Transcribe(ctx context.Context, stream *ObjectStream) (*ObjectTranscript, error) //Returns the transcript of a stream, without changing the stream, giving up once ctx is done
+}
+
+// TranscriberConfig holds the configuration of a transcriber within the chain
+type TranscriberConfig struct {
+	Name    string `json:"name"`    //The transcriber to use, ex: source, sidecar, lyrics
+	Timeout string `json:"timeout"` //How long to wait for a transcript before moving on to the next transcriber, ex: 10s
+	Path    string `json:"path"`    //The directory of sidecar files, for the sidecar transcriber
+}
+
+// transcriberLink holds a transcriber ready to run within the chain
+type transcriberLink struct {
+	Transcriber
+	timeout time.Duration
+}
+
+// LoadTranscribers sets up the configured chain of transcribers, skipping any that fail
+func (s *Service) LoadTranscribers() (err error) {
+	configs := s.Transcribers
+	if len(configs) == 0 {
+		configs = defaultTranscribers
+	}
+	s.transcribers = make([]*transcriberLink, 0, len(configs))
+	for i := 0; i < len(configs); i++ {
+		cfg := configs[i]
+		newTranscriber, exists := transcribers[cfg.Name]
+		if !exists {
+			err = NewError(ErrBadURI, "", "no transcriber %s", cfg.Name)
+			Error.Println(err)
+			continue
+		}
+		transcriber, tErr := newTranscriber(cfg)
+		if tErr != nil {
+			err = WrapError(tErr, "", "failed to load transcriber %s", cfg.Name)
+			Error.Println(err)
+			continue
+		}
+		timeout := transcriberTimeout
+		if cfg.Timeout != "" {
+			if timeout, tErr = time.ParseDuration(cfg.Timeout); tErr != nil {
+				Warning.Printf("Invalid timeout %s for transcriber %s, using the default: %v\n", cfg.Timeout, cfg.Name, tErr)
+				timeout = transcriberTimeout
+			}
+		}
+		s.transcribers = append(s.transcribers, &transcriberLink{Transcriber: transcriber, timeout: timeout})
+	}
+	return
+}
+
+// Transcribe returns the first transcript found for a stream by the chain of transcribers, or nil if none had one in time
+func (s *Service) Transcribe(stream *ObjectStream) *ObjectTranscript {
+	for i := 0; i < len(s.transcribers); i++ {
+		link := s.transcribers[i]
+		//Cancelling tells the transcriber to give up and let go of anything it holds, like its provider's client
+		ctx, cancel := context.WithTimeout(context.Background(), link.timeout)
+		result := make(chan *ObjectTranscript, 1)
+		go func() {
+			transcript, err := link.Transcribe(ctx, stream)
+			if err != nil {
+				Trace.Printf("No transcript for %s from %s: %v\n", stream.URI, link.Name(), err)
+				transcript = nil
+			}
+			result <- transcript
+		}()
+		select {
+		case transcript := <-result:
+			cancel()
+			if transcript == nil || len(transcript.Lines) == 0 {
+				continue
+			}
+			if transcript.Provider == "" {
+				transcript.Provider = link.Name()
+			}
+			return transcript
+		case <-ctx.Done():
+			cancel()
+			Warning.Printf("Transcriber %s timed out on %s after %v\n", link.Name(), stream.URI, link.timeout)
+		}
+	}
+	return nil
+}
+
+// sourceTranscriber asks the stream's own provider for its transcript
+type sourceTranscriber struct{}
+
+func newSourceTranscriber(cfg *TranscriberConfig) (Transcriber, error) {
+	return &sourceTranscriber{}, nil
+}
+
+func (t *sourceTranscriber) Name() string {
+	return "source"
+}
+
+func (t *sourceTranscriber) Transcribe(ctx context.Context, stream *ObjectStream) (*ObjectTranscript, error) {
+	handler, exists := GetHandler(stream.Provider)
+	if !exists {
+		return nil, NewError(ErrNotFound, stream.Provider, "no handler for provider %s", stream.Provider)
+	}
+	if err := service.LoginError(stream.Provider); err != nil {
+		return nil, NewError(ErrProviderUnavailable, stream.Provider, "%s: not logged in: %v", stream.Provider, err)
+	}
+	//Handlers fill in the stream they're given, so give them a copy in case they finish after timing out
+	transcribed := *stream
+	transcribed.Transcript = nil
+	if err := handler.Transcribe(ctx, &transcribed); err != nil {
+		return nil, err
+	}
+	if transcribed.Transcript != nil {
+		transcribed.Transcript.Provider = stream.Provider
+		if transcribed.Transcript.ProviderName == "" {
+			transcribed.Transcript.ProviderName = handler.About().Name
+		}
+	}
+	return transcribed.Transcript, nil
+}
+
+// lyricsTranscriber searches lyric-api-go by the stream's creators and name, returning unsynced lyrics
+type lyricsTranscriber struct{}
+
+func newLyricsTranscriber(cfg *TranscriberConfig) (Transcriber, error) {
+	return &lyricsTranscriber{}, nil
+}
+
+func (t *lyricsTranscriber) Name() string {
+	return "lyrics"
+}
+
+func (t *lyricsTranscriber) Transcribe(ctx context.Context, stream *ObjectStream) (*ObjectTranscript, error) {
+	//Make sure we at least know the creator and stream names first
+	if len(stream.Creators) == 0 || stream.Name == "" {
+		return nil, NewError(ErrNotFound, "", "lyrics: need creator and stream names")
+	}
+	l := lyrics.New()
+
+	//We want the first creator that matches a result
+	for i := 0; i < len(stream.Creators) && ctx.Err() == nil; i++ {
+		creator := stream.Creators[i].Creator()
+		if creator == nil {
+			continue
+		}
+		text, err := l.Search(creator.Name, stream.Name)
+		if err != nil {
+			continue
+		}
+		transcript := parseTranscriptText(text)
+		transcript.ProviderLyricsID = stream.ID
+		transcript.ProviderTrackID = stream.ID
+		return transcript, nil
+	}
+	return nil, NewError(ErrNotFound, "", "lyrics: no lyrics for %s", stream.Name)
+}
+
+// sidecarTranscriber reads .lrc or .txt files from a directory, named by ISRC or as creator/name
+type sidecarTranscriber struct {
+	path string
+}
+
+func newSidecarTranscriber(cfg *TranscriberConfig) (Transcriber, error) {
+	path := cfg.Path
+	if path == "" {
+		path = sidecarPath
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, NewError(ErrBadURI, "", "sidecar: %s is not a directory", path)
+	}
+	return &sidecarTranscriber{path: path}, nil
+}
+
+func (t *sidecarTranscriber) Name() string {
+	return "sidecar"
+}
+
+func (t *sidecarTranscriber) Transcribe(ctx context.Context, stream *ObjectStream) (*ObjectTranscript, error) {
+	names := make([]string, 0)
+	if stream.ISRC != "" {
+		names = append(names, strings.ToUpper(stream.ISRC))
+	}
+	for i := 0; i < len(stream.Creators); i++ {
+		if creator := stream.Creators[i].Creator(); creator != nil && creator.Name != "" && stream.Name != "" {
+			names = append(names, filepath.Join(sidecarName(creator.Name), sidecarName(stream.Name)))
+		}
+	}
+
+	//Prefer timed lyrics over plain text under any name
+	for _, ext := range []string{".lrc", ".txt"} {
+		for i := 0; i < len(names); i++ {
+			path := sidecarFind(t.path, names[i]+ext)
+			if path == "" {
+				continue
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			Trace.Println("Found sidecar transcript " + path + " for " + stream.URI)
+			var transcript *ObjectTranscript
+			if ext == ".lrc" {
+				transcript = parseLRC(string(data))
+			} else {
+				transcript = parseTranscriptText(string(data))
+			}
+			transcript.ProviderLyricsID, _ = filepath.Rel(t.path, path)
+			transcript.ProviderTrackID = stream.URI
+			return transcript, nil
+		}
+	}
+	return nil, NewError(ErrNotFound, "", "sidecar: no transcript for %s", stream.URI)
+}
+
+// sidecarName returns a name that's safe to use as a single path element
+func sidecarName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(strings.TrimSpace(name))
+}
+
+// sidecarFind returns the path of a file within a directory, matching each path element regardless of case, or an empty string if there's no such file
+func sidecarFind(dir, name string) string {
+	elements := strings.Split(filepath.ToSlash(name), "/")
+	for i := 0; i < len(elements); i++ {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return ""
+		}
+		found := ""
+		for j := 0; j < len(entries); j++ {
+			if entries[j].Name() == elements[i] {
+				found = entries[j].Name()
+				break
+			}
+			if found == "" && strings.EqualFold(entries[j].Name(), elements[i]) {
+				found = entries[j].Name()
+			}
+		}
+		if found == "" {
+			return ""
+		}
+		dir = filepath.Join(dir, found)
+	}
+	return dir
+}
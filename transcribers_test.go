@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingTranscriber holds on until it's told to give up, like a provider that never responds
+type blockingTranscriber struct {
+	released chan struct{}
+}
+
+func (t *blockingTranscriber) Name() string {
+	return "blocking"
+}
+
+func (t *blockingTranscriber) Transcribe(ctx context.Context, stream *ObjectStream) (*ObjectTranscript, error) {
+	<-ctx.Done()
+	close(t.released)
+	return nil, ctx.Err()
+}
+
+// staticTranscriber always finds the same transcript
+type staticTranscriber struct{}
+
+func (t *staticTranscriber) Name() string {
+	return "static"
+}
+
+func (t *staticTranscriber) Transcribe(ctx context.Context, stream *ObjectStream) (*ObjectTranscript, error) {
+	return &ObjectTranscript{Lines: []*ObjectTranscriptLine{{Text: "One more time"}}}, nil
+}
+
+func TestTranscribeTimeout(t *testing.T) {
+	blocking := &blockingTranscriber{released: make(chan struct{})}
+	s := &Service{transcribers: []*transcriberLink{
+		{Transcriber: blocking, timeout: time.Millisecond * 10},
+		{Transcriber: &staticTranscriber{}, timeout: time.Second},
+	}}
+	transcript := s.Transcribe(&ObjectStream{URI: "tidal:track:1"})
+	if transcript == nil || transcript.Provider != "static" {
+		t.Fatalf("Transcribe() = %+v, want the transcript of the next transcriber", transcript)
+	}
+	select {
+	case <-blocking.released:
+	case <-time.After(time.Second):
+		t.Errorf("the transcriber that timed out was never told to give up")
+	}
+}
+
+func TestSidecarTranscriber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libremedia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "transcripts")
+	files := map[string]string{
+		"transcripts/GBDUW0000059.lrc":               "[00:01.00]One more time\n",
+		"transcripts/GBDUW0000059.txt":               "One more time\n",
+		"transcripts/daft punk/aerodynamic.txt":      "Aerodynamic\n",
+		"transcripts/AC_DC/Back In Black.txt":        "Back in black\n",
+		"transcripts/Justice/D.A.N.C.E..lrc":         "[00:02.00]Do the dance\n",
+		"transcripts/Justice/D.A.N.C.E..txt":         "Do the dance\n",
+		"secret/Back In Black.txt":                   "Outside the sidecar directory\n",
+		"transcripts/daft punk/Digital Love.txt.bak": "Not a transcript\n",
+	}
+	for name, data := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0777)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sidecar, err := newSidecarTranscriber(&TranscriberConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{transcribers: []*transcriberLink{{Transcriber: sidecar, timeout: time.Second}}}
+
+	tests := []struct {
+		name   string
+		stream *ObjectStream
+		want   string //The file the transcript should come from, or empty for none
+	}{
+		{"isrc prefers lrc", testStream("tidal", "1", "One More Time", "Daft Punk", "gbduw0000059", 320).Stream(), "GBDUW0000059.lrc"},
+		{"creator and name regardless of case", testStream("tidal", "2", "Aerodynamic", "Daft Punk", "", 212).Stream(), filepath.Join("daft punk", "aerodynamic.txt")},
+		{"creator and name prefers lrc", testStream("tidal", "3", "D.A.N.C.E.", "Justice", "", 242).Stream(), filepath.Join("Justice", "D.A.N.C.E..lrc")},
+		{"slash stays within a name", testStream("tidal", "4", "Back In Black", "AC/DC", "", 255).Stream(), filepath.Join("AC_DC", "Back In Black.txt")},
+		{"slash can't leave the directory", testStream("tidal", "5", "Back In Black", "../secret", "", 255).Stream(), ""},
+		{"no matching file", testStream("tidal", "6", "Digital Love", "Daft Punk", "", 301).Stream(), ""},
+	}
+	for _, test := range tests {
+		test.stream.URI = "tidal:track:" + test.name
+		transcript := s.Transcribe(test.stream)
+		if test.want == "" {
+			if transcript != nil {
+				t.Errorf("%s: found %s, want no transcript", test.name, transcript.ProviderLyricsID)
+			}
+			continue
+		}
+		if transcript == nil {
+			t.Errorf("%s: found no transcript, want %s", test.name, test.want)
+			continue
+		}
+		if transcript.ProviderLyricsID != test.want {
+			t.Errorf("%s: found %s, want %s", test.name, transcript.ProviderLyricsID, test.want)
+		}
+		if transcript.Provider != "sidecar" {
+			t.Errorf("%s: provider = %q, want sidecar", test.name, transcript.Provider)
+		}
+	}
+}
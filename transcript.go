@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// View returns a copy of the transcript timed at the requested granularity, merging syllables into words or dropping segments as needed
+func (obj *ObjectTranscript) View(granularity string) (*ObjectTranscript, error) {
+	switch granularity {
+	case "":
+		return obj, nil
+	case TranscriptLineSynced, TranscriptWordSynced, TranscriptSyllableSynced:
+	default:
+		return nil, NewError(ErrBadURI, obj.Provider, "transcript: no granularity %s, try line, word or syllable", granularity)
+	}
+	syncType := obj.SyncType
+	if syncType == "" {
+		//Transcripts cached before sync types existed only say whether they're timed
+		syncType = TranscriptUnsynced
+		if obj.TimeSynced {
+			syncType = TranscriptLineSynced
+		}
+	}
+	if transcriptRank(syncType) < transcriptRank(granularity) {
+		return nil, NewError(ErrNotFound, obj.Provider, "transcript: no %s timing, only %s", granularity, syncType)
+	}
+
+	view := *obj
+	view.SyncType = granularity
+	view.Lines = make([]*ObjectTranscriptLine, len(obj.Lines))
+	for i := 0; i < len(obj.Lines); i++ {
+		line := *obj.Lines[i]
+		switch granularity {
+		case TranscriptLineSynced:
+			line.Segments = nil
+		case TranscriptWordSynced:
+			if syncType == TranscriptSyllableSynced {
+				line.Segments = transcriptWords(line.Segments)
+			}
+		}
+		view.Lines[i] = &line
+	}
+	return &view, nil
+}
+
+// transcriptRank orders sync types from coarsest to finest
+func transcriptRank(syncType string) int {
+	switch syncType {
+	case TranscriptLineSynced:
+		return 1
+	case TranscriptWordSynced:
+		return 2
+	case TranscriptSyllableSynced:
+		return 3
+	}
+	return 0
+}
+
+// transcriptWords merges the syllables of a line into words, ending a word at each syllable followed by whitespace
+func transcriptWords(syllables []*ObjectTranscriptSegment) []*ObjectTranscriptSegment {
+	words := make([]*ObjectTranscriptSegment, 0)
+	var word *ObjectTranscriptSegment
+	for i := 0; i < len(syllables); i++ {
+		if word == nil {
+			word = &ObjectTranscriptSegment{StartTimeMs: syllables[i].StartTimeMs}
+		}
+		word.Text += syllables[i].Text
+		word.EndTimeMs = syllables[i].EndTimeMs
+		if strings.TrimRightFunc(syllables[i].Text, unicode.IsSpace) != syllables[i].Text || i == len(syllables)-1 {
+			words = append(words, word)
+			word = nil
+		}
+	}
+	return words
+}
+
+// transcriptEnds fills in the end of each segment that has none with the start of the next one, and the last with the end of the line
+func transcriptEnds(line *ObjectTranscriptLine) {
+	for i := 0; i < len(line.Segments); i++ {
+		if line.Segments[i].EndTimeMs > 0 {
+			continue
+		}
+		if i+1 < len(line.Segments) {
+			line.Segments[i].EndTimeMs = line.Segments[i+1].StartTimeMs
+		} else {
+			line.Segments[i].EndTimeMs = line.EndTimeMs
+		}
+	}
+}
+
+// parseLRCTime returns the milliseconds of an LRC timestamp without its brackets, ex: 01:23.45
+func parseLRCTime(timestamp string) (int, bool) {
+	var min, sec, frac int
+	var fracText string
+	if n, _ := fmt.Sscanf(strings.Replace(timestamp, ".", " ", 1), "%d:%d %s", &min, &sec, &fracText); n < 2 {
+		return 0, false
+	}
+	if fracText != "" {
+		if _, err := fmt.Sscanf(fracText, "%d", &frac); err != nil {
+			return 0, false
+		}
+		//Centiseconds or milliseconds, depending on how many digits there are
+		for i := len(fracText); i < 3; i++ {
+			frac *= 10
+		}
+		for i := len(fracText); i > 3; i-- {
+			frac /= 10
+		}
+	}
+	return (min*60+sec)*1000 + frac, true
+}
+
+// parseLRCWords splits a line of enhanced LRC into its text and timed segments, ex: <00:12.34> Hello <00:12.80> world <00:13.50>
+// Segments without whitespace between them are syllables of the same word, so the finest sync type found is returned too
+func parseLRCWords(text string) (string, []*ObjectTranscriptSegment, string) {
+	start := nextLRCTime(text)
+	if start == len(text) {
+		return strings.TrimSpace(text), nil, ""
+	}
+	prefix := strings.TrimSpace(text[:start])
+	text = text[start:]
+
+	chunks := make([]string, 0)
+	times := make([]int, 0)
+	for text != "" {
+		end := strings.Index(text, ">")
+		timeMs, _ := parseLRCTime(text[1:end])
+		text = text[end+1:]
+		//Any text up to the next timestamp belongs to this one, even if it holds a "<" of its own
+		next := nextLRCTime(text)
+		chunks = append(chunks, text[:next])
+		times = append(times, timeMs)
+		text = text[next:]
+	}
+	segments := make([]*ObjectTranscriptSegment, 0)
+	syncType := TranscriptWordSynced
+	for i := 0; i < len(chunks); i++ {
+		word := strings.TrimSpace(chunks[i])
+		if word == "" {
+			//An empty chunk closes the previous segment
+			if len(segments) > 0 {
+				segments[len(segments)-1].EndTimeMs = times[i]
+			}
+			continue
+		}
+		segment := &ObjectTranscriptSegment{StartTimeMs: times[i], Text: word}
+		if len(segments) > 0 {
+			last := segments[len(segments)-1]
+			if strings.TrimLeftFunc(chunks[i], unicode.IsSpace) == chunks[i] && strings.TrimRightFunc(chunks[i-1], unicode.IsSpace) == chunks[i-1] && strings.TrimSpace(chunks[i-1]) != "" {
+				syncType = TranscriptSyllableSynced
+			} else {
+				last.Text += " "
+			}
+		}
+		segments = append(segments, segment)
+	}
+
+	line := ""
+	for i := 0; i < len(segments); i++ {
+		line += segments[i].Text
+	}
+	if prefix != "" {
+		line = prefix + " " + line
+	}
+	return line, segments, syncType
+}
+
+// nextLRCTime returns the index of the next enhanced LRC timestamp in a line, or the length of the line if there isn't one
+func nextLRCTime(text string) int {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '<' {
+			continue
+		}
+		end := strings.Index(text[i:], ">")
+		if end == -1 {
+			break
+		}
+		if _, ok := parseLRCTime(text[i+1 : i+end]); ok {
+			return i
+		}
+	}
+	return len(text)
+}
+
+// parseTranscriptText returns an unsynced transcript with a line for each line of text
+func parseTranscriptText(text string) *ObjectTranscript {
+	transcript := &ObjectTranscript{SyncType: TranscriptUnsynced, Lines: make([]*ObjectTranscriptLine, 0)}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		transcript.Lines = append(transcript.Lines, &ObjectTranscriptLine{Text: lines[i]})
+	}
+	return transcript
+}
+
+// parseLRC returns the transcript of LRC lyrics, including enhanced LRC word timing, lines with several timestamps, and the offset and language tags
+func parseLRC(text string) *ObjectTranscript {
+	transcript := &ObjectTranscript{SyncType: TranscriptUnsynced, Lines: make([]*ObjectTranscriptLine, 0)}
+	offset := 0
+	repeated := false
+	keys := make([]int, 0) //The time each line is sorted by, untimed lines staying after the line before them
+	lastKey := 0
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		times := make([]int, 0)
+		tagged := false
+		for strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end == -1 {
+				break
+			}
+			tag := line[1:end]
+			if timeMs, ok := parseLRCTime(tag); ok {
+				times = append(times, timeMs)
+			} else if key, value, ok := strings.Cut(tag, ":"); ok {
+				switch strings.ToLower(strings.TrimSpace(key)) {
+				case "offset":
+					fmt.Sscanf(strings.TrimSpace(value), "%d", &offset)
+				case "la", "lang", "language":
+					transcript.Language = strings.TrimSpace(value)
+				}
+			} else {
+				break
+			}
+			tagged = true
+			line = line[end+1:]
+		}
+		if len(times) == 0 {
+			if !tagged {
+				transcript.Lines = append(transcript.Lines, &ObjectTranscriptLine{Text: line})
+				keys = append(keys, lastKey)
+			}
+			continue
+		}
+
+		transcript.TimeSynced = true
+		txt, segments, syncType := parseLRCWords(line)
+		if syncType == "" {
+			syncType = TranscriptLineSynced
+		}
+		if transcriptRank(syncType) > transcriptRank(transcript.SyncType) {
+			transcript.SyncType = syncType
+		}
+		//A line sung more than once lists every time it starts
+		repeated = repeated || len(times) > 1
+		lastKey = times[0]
+		for j := 0; j < len(times); j++ {
+			objLine := &ObjectTranscriptLine{StartTimeMs: times[j], Text: txt}
+			for k := 0; k < len(segments); k++ {
+				segment := *segments[k]
+				segment.StartTimeMs += times[j] - times[0]
+				if segment.EndTimeMs > 0 {
+					segment.EndTimeMs += times[j] - times[0]
+				}
+				objLine.Segments = append(objLine.Segments, &segment)
+			}
+			transcriptEnds(objLine)
+			transcript.Lines = append(transcript.Lines, objLine)
+			keys = append(keys, times[j])
+		}
+	}
+	if repeated {
+		sort.Stable(&transcriptSorter{lines: transcript.Lines, keys: keys})
+	}
+
+	//A positive offset shows every line sooner
+	if offset != 0 && transcript.TimeSynced {
+		shift := func(timeMs int) int {
+			if timeMs -= offset; timeMs < 0 {
+				return 0
+			}
+			return timeMs
+		}
+		for i := 0; i < len(transcript.Lines); i++ {
+			line := transcript.Lines[i]
+			line.StartTimeMs = shift(line.StartTimeMs)
+			if line.EndTimeMs > 0 {
+				line.EndTimeMs = shift(line.EndTimeMs)
+			}
+			for j := 0; j < len(line.Segments); j++ {
+				line.Segments[j].StartTimeMs = shift(line.Segments[j].StartTimeMs)
+				if line.Segments[j].EndTimeMs > 0 {
+					line.Segments[j].EndTimeMs = shift(line.Segments[j].EndTimeMs)
+				}
+			}
+		}
+	}
+	return transcript
+}
+
+// transcriptSorter sorts the lines of a transcript by a key for each line
+type transcriptSorter struct {
+	lines []*ObjectTranscriptLine
+	keys  []int
+}
+
+func (s *transcriptSorter) Len() int           { return len(s.lines) }
+func (s *transcriptSorter) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s *transcriptSorter) Swap(i, j int) {
+	s.lines[i], s.lines[j] = s.lines[j], s.lines[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLRCTime(t *testing.T) {
+	tests := []struct {
+		timestamp string
+		want      int
+		ok        bool
+	}{
+		{timestamp: "01:23.45", want: 83450, ok: true},
+		{timestamp: "01:23.456", want: 83456, ok: true},
+		{timestamp: "01:23.4", want: 83400, ok: true},
+		{timestamp: "01:23.4567", want: 83456, ok: true},
+		{timestamp: "01:23", want: 83000, ok: true},
+		{timestamp: "00:00.00", want: 0, ok: true},
+		{timestamp: "61:00.00", want: 3660000, ok: true},
+		{timestamp: "ar:Daft Punk"},
+		{timestamp: "01:ab"},
+		{timestamp: "01:23.ab"},
+		{timestamp: "3"},
+		{timestamp: ""},
+	}
+	for _, test := range tests {
+		got, ok := parseLRCTime(test.timestamp)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseLRCTime(%q) = %d, %v, want %d, %v", test.timestamp, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseLRCWords(t *testing.T) {
+	tests := []struct {
+		text     string
+		line     string
+		segments []*ObjectTranscriptSegment
+		syncType string
+	}{
+		{text: "One more time", line: "One more time"},
+		{text: "  One more time ", line: "One more time"},
+		{text: "<bad>One more time", line: "<bad>One more time"},
+		{
+			text:     "<00:12.34> Hello <00:12.80> world <00:13.50>",
+			line:     "Hello world",
+			segments: []*ObjectTranscriptSegment{{StartTimeMs: 12340, Text: "Hello "}, {StartTimeMs: 12800, EndTimeMs: 13500, Text: "world"}},
+			syncType: TranscriptWordSynced,
+		},
+		{
+			text:     "<00:01.00>Hel<00:01.50>lo <00:02.00>world",
+			line:     "Hello world",
+			segments: []*ObjectTranscriptSegment{{StartTimeMs: 1000, Text: "Hel"}, {StartTimeMs: 1500, Text: "lo "}, {StartTimeMs: 2000, Text: "world"}},
+			syncType: TranscriptSyllableSynced,
+		},
+		{
+			text:     "<00:01.00>I <3 you",
+			line:     "I <3 you",
+			segments: []*ObjectTranscriptSegment{{StartTimeMs: 1000, Text: "I <3 you"}},
+			syncType: TranscriptWordSynced,
+		},
+		{
+			text:     "<00:01.00>I <3 <00:02.00>you <00:03.00>",
+			line:     "I <3 you",
+			segments: []*ObjectTranscriptSegment{{StartTimeMs: 1000, Text: "I <3 "}, {StartTimeMs: 2000, EndTimeMs: 3000, Text: "you"}},
+			syncType: TranscriptWordSynced,
+		},
+		{
+			text:     "<00:01.00>a <00:02.00>b <oops",
+			line:     "a b <oops",
+			segments: []*ObjectTranscriptSegment{{StartTimeMs: 1000, Text: "a "}, {StartTimeMs: 2000, Text: "b <oops"}},
+			syncType: TranscriptWordSynced,
+		},
+		{
+			text:     "I <3 <00:01.00>you",
+			line:     "I <3 you",
+			segments: []*ObjectTranscriptSegment{{StartTimeMs: 1000, Text: "you"}},
+			syncType: TranscriptWordSynced,
+		},
+	}
+	for _, test := range tests {
+		line, segments, syncType := parseLRCWords(test.text)
+		if line != test.line {
+			t.Errorf("parseLRCWords(%q) line = %q, want %q", test.text, line, test.line)
+		}
+		if !reflect.DeepEqual(segments, test.segments) {
+			t.Errorf("parseLRCWords(%q) segments = %s, want %s", test.text, mustJSON(t, segments), mustJSON(t, test.segments))
+		}
+		if syncType != test.syncType {
+			t.Errorf("parseLRCWords(%q) sync type = %q, want %q", test.text, syncType, test.syncType)
+		}
+	}
+}
+
+func TestTranscriptView(t *testing.T) {
+	syllables := &ObjectTranscript{TimeSynced: true, SyncType: TranscriptSyllableSynced, Lines: []*ObjectTranscriptLine{{
+		StartTimeMs: 1000,
+		EndTimeMs:   2500,
+		Text:        "Hello world",
+		Segments: []*ObjectTranscriptSegment{
+			{StartTimeMs: 1000, EndTimeMs: 1500, Text: "Hel"},
+			{StartTimeMs: 1500, EndTimeMs: 2000, Text: "lo "},
+			{StartTimeMs: 2000, EndTimeMs: 2500, Text: "world"},
+		},
+	}}}
+	lines := &ObjectTranscript{TimeSynced: true, SyncType: TranscriptLineSynced, Lines: []*ObjectTranscriptLine{{StartTimeMs: 1000, Text: "Hello world"}}}
+	legacy := &ObjectTranscript{TimeSynced: true, Lines: []*ObjectTranscriptLine{{StartTimeMs: 1000, Text: "Hello world"}}}
+	unsynced := &ObjectTranscript{SyncType: TranscriptUnsynced, Lines: []*ObjectTranscriptLine{{Text: "Hello world"}}}
+
+	tests := []struct {
+		name        string
+		transcript  *ObjectTranscript
+		granularity string
+		segments    []*ObjectTranscriptSegment
+		wantErr     string
+	}{
+		{name: "syllables as is", transcript: syllables, granularity: TranscriptSyllableSynced, segments: syllables.Lines[0].Segments},
+		{
+			name:        "syllables as words",
+			transcript:  syllables,
+			granularity: TranscriptWordSynced,
+			segments:    []*ObjectTranscriptSegment{{StartTimeMs: 1000, EndTimeMs: 2000, Text: "Hello "}, {StartTimeMs: 2000, EndTimeMs: 2500, Text: "world"}},
+		},
+		{name: "syllables as lines", transcript: syllables, granularity: TranscriptLineSynced},
+		{name: "lines as lines", transcript: lines, granularity: TranscriptLineSynced},
+		{name: "lines as words", transcript: lines, granularity: TranscriptWordSynced, wantErr: ErrNotFound},
+		{name: "legacy as lines", transcript: legacy, granularity: TranscriptLineSynced},
+		{name: "legacy as words", transcript: legacy, granularity: TranscriptWordSynced, wantErr: ErrNotFound},
+		{name: "unsynced as lines", transcript: unsynced, granularity: TranscriptLineSynced, wantErr: ErrNotFound},
+		{name: "unknown granularity", transcript: syllables, granularity: "letter", wantErr: ErrBadURI},
+	}
+	for _, test := range tests {
+		before := mustJSON(t, test.transcript)
+		view, err := test.transcript.View(test.granularity)
+		if test.wantErr != "" {
+			objErr, ok := err.(*ObjectError)
+			if !ok || objErr.Code != test.wantErr {
+				t.Errorf("%s: returned %v, want error %s", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+			continue
+		}
+		if view.SyncType != test.granularity {
+			t.Errorf("%s: view has sync type %q, want %q", test.name, view.SyncType, test.granularity)
+		}
+		if len(view.Lines) != 1 || view.Lines[0].Text != "Hello world" || view.Lines[0].StartTimeMs != 1000 {
+			t.Errorf("%s: view has lines %s", test.name, mustJSON(t, view.Lines))
+		} else if !reflect.DeepEqual(view.Lines[0].Segments, test.segments) {
+			t.Errorf("%s: view has segments %s, want %s", test.name, mustJSON(t, view.Lines[0].Segments), mustJSON(t, test.segments))
+		}
+		if after := mustJSON(t, test.transcript); after != before {
+			t.Errorf("%s: viewing changed the transcript:\n got %s\nwant %s", test.name, after, before)
+		}
+	}
+
+	if view, err := syllables.View(""); err != nil || view != syllables {
+		t.Errorf("View(\"\") = %p, %v, want the transcript itself", view, err)
+	}
+}
+
+func TestParseLRC(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want *ObjectTranscript
+	}{
+		{
+			name: "unsynced",
+			text: "One more time\r\nWe're gonna celebrate\n",
+			want: &ObjectTranscript{SyncType: TranscriptUnsynced, Lines: []*ObjectTranscriptLine{{Text: "One more time"}, {Text: "We're gonna celebrate"}}},
+		},
+		{
+			name: "line synced with tags",
+			text: "[ar:Daft Punk]\n[la:en]\n[00:01.00]One more time\n[00:02.50]We're gonna celebrate",
+			want: &ObjectTranscript{Language: "en", TimeSynced: true, SyncType: TranscriptLineSynced, Lines: []*ObjectTranscriptLine{
+				{StartTimeMs: 1000, Text: "One more time"},
+				{StartTimeMs: 2500, Text: "We're gonna celebrate"},
+			}},
+		},
+		{
+			name: "repeated lines",
+			text: "[00:01.00][00:03.00]One more time\n[00:02.00]Celebrate\n(instrumental)",
+			want: &ObjectTranscript{TimeSynced: true, SyncType: TranscriptLineSynced, Lines: []*ObjectTranscriptLine{
+				{StartTimeMs: 1000, Text: "One more time"},
+				{StartTimeMs: 2000, Text: "Celebrate"},
+				{Text: "(instrumental)"},
+				{StartTimeMs: 3000, Text: "One more time"},
+			}},
+		},
+		{
+			name: "word synced with offset",
+			text: "[offset:500]\n[00:01.00]<00:01.00>One <00:01.40>more <00:01.80>time <00:02.20>",
+			want: &ObjectTranscript{TimeSynced: true, SyncType: TranscriptWordSynced, Lines: []*ObjectTranscriptLine{{
+				StartTimeMs: 500,
+				Text:        "One more time",
+				Segments: []*ObjectTranscriptSegment{
+					{StartTimeMs: 500, EndTimeMs: 900, Text: "One "},
+					{StartTimeMs: 900, EndTimeMs: 1300, Text: "more "},
+					{StartTimeMs: 1300, EndTimeMs: 1700, Text: "time"},
+				},
+			}}},
+		},
+		{
+			name: "repeated word synced line",
+			text: "[00:01.00][00:05.00]<00:01.00>Hel<00:01.50>lo <00:02.00>",
+			want: &ObjectTranscript{TimeSynced: true, SyncType: TranscriptSyllableSynced, Lines: []*ObjectTranscriptLine{
+				{StartTimeMs: 1000, Text: "Hello", Segments: []*ObjectTranscriptSegment{{StartTimeMs: 1000, EndTimeMs: 1500, Text: "Hel"}, {StartTimeMs: 1500, EndTimeMs: 2000, Text: "lo"}}},
+				{StartTimeMs: 5000, Text: "Hello", Segments: []*ObjectTranscriptSegment{{StartTimeMs: 5000, EndTimeMs: 5500, Text: "Hel"}, {StartTimeMs: 5500, EndTimeMs: 6000, Text: "lo"}}},
+			}},
+		},
+		{
+			name: "offset past the start",
+			text: "[offset:2000]\n[00:01.00]One more time",
+			want: &ObjectTranscript{TimeSynced: true, SyncType: TranscriptLineSynced, Lines: []*ObjectTranscriptLine{{StartTimeMs: 0, Text: "One more time"}}},
+		},
+	}
+	for _, test := range tests {
+		got := parseLRC(test.text)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseLRC(%q) =\n%s\nwant\n%s", test.name, test.text, mustJSON(t, got), mustJSON(t, test.want))
+		}
+	}
+}
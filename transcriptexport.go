@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+const (
+	transcriptCueLength = 5000     //How long a cue lasts in milliseconds when nothing says when it ends
+	rightToLeftMark     = "\u200f" //Marks a line of subtitles as right-to-left for formats without a direction setting
+)
+
+// Content types of each transcript export format
+var transcriptFormats = map[string]string{
+	"lrc":  "text/plain; charset=utf-8",
+	"vtt":  "text/vtt; charset=utf-8",
+	"srt":  "application/x-subrip; charset=utf-8",
+	"ttml": "application/ttml+xml; charset=utf-8",
+}
+
+// transcriptCue holds a line of a transcript with the time it's shown until
+type transcriptCue struct {
+	*ObjectTranscriptLine
+	StartTimeMs int
+	EndTimeMs   int
+}
+
+// Export renders the transcript in a standard format, ex: lrc, vtt, srt, ttml, and returns it with its content type
+// The duration of the stream is used to end the last line, and to show unsynced transcripts for the whole stream
+func (obj *ObjectTranscript) Export(format string, durationMs int) ([]byte, string, error) {
+	contentType, exists := transcriptFormats[format]
+	if !exists {
+		return nil, "", NewError(ErrBadURI, obj.Provider, "transcript: no export format %s, try lrc, vtt, srt or ttml", format)
+	}
+	cues := obj.cues(durationMs)
+	var data []byte
+	switch format {
+	case "lrc":
+		data = obj.exportLRC(durationMs)
+	case "vtt":
+		data = obj.exportVTT(cues)
+	case "srt":
+		data = obj.exportSRT(cues)
+	case "ttml":
+		data = obj.exportTTML(cues)
+	}
+	return data, contentType, nil
+}
+
+// cues returns the lines of the transcript that have text, each with when it's shown from and until
+// An unsynced transcript becomes one cue for the whole stream, as there's no way to tell when each line is sung
+func (obj *ObjectTranscript) cues(durationMs int) []*transcriptCue {
+	cues := make([]*transcriptCue, 0)
+	if !obj.TimeSynced {
+		text := make([]string, 0)
+		for i := 0; i < len(obj.Lines); i++ {
+			if strings.TrimSpace(obj.Lines[i].Text) != "" {
+				text = append(text, obj.Lines[i].Text)
+			}
+		}
+		if len(text) == 0 {
+			return cues
+		}
+		endTimeMs := durationMs
+		if endTimeMs <= 0 {
+			endTimeMs = len(text) * transcriptCueLength
+		}
+		line := &ObjectTranscriptLine{Text: strings.Join(text, "\n")}
+		return append(cues, &transcriptCue{ObjectTranscriptLine: line, EndTimeMs: endTimeMs})
+	}
+
+	//Untimed lines within a synced transcript follow the line before them
+	starts := make([]int, len(obj.Lines))
+	for i := 0; i < len(obj.Lines); i++ {
+		starts[i] = obj.Lines[i].StartTimeMs
+		if i > 0 && starts[i] < starts[i-1] {
+			starts[i] = starts[i-1]
+		}
+	}
+	for i := 0; i < len(obj.Lines); i++ {
+		line := obj.Lines[i]
+		if strings.TrimSpace(line.Text) == "" {
+			continue //Empty lines only mark when the line before them ends
+		}
+		cue := &transcriptCue{ObjectTranscriptLine: line, StartTimeMs: starts[i], EndTimeMs: line.EndTimeMs}
+		for j := i + 1; j < len(obj.Lines) && cue.EndTimeMs <= cue.StartTimeMs; j++ {
+			if starts[j] > cue.StartTimeMs {
+				cue.EndTimeMs = starts[j]
+			}
+		}
+		if cue.EndTimeMs <= cue.StartTimeMs {
+			cue.EndTimeMs = cue.StartTimeMs + transcriptCueLength
+			if durationMs > cue.StartTimeMs {
+				cue.EndTimeMs = durationMs
+			}
+		}
+		cues = append(cues, cue)
+	}
+	return cues
+}
+
+// exportLRC renders the transcript as LRC, with enhanced LRC word timing where the transcript has it
+func (obj *ObjectTranscript) exportLRC(durationMs int) []byte {
+	buf := &bytes.Buffer{}
+	if obj.Language != "" {
+		fmt.Fprintf(buf, "[la:%s]\n", obj.Language)
+	}
+	if durationMs > 0 {
+		fmt.Fprintf(buf, "[length:%02d:%02d]\n", durationMs/60000, durationMs/1000%60)
+	}
+	for i := 0; i < len(obj.Lines); i++ {
+		line := obj.Lines[i]
+		if !obj.TimeSynced || (line.StartTimeMs == 0 && i > 0) {
+			buf.WriteString(line.Text + "\n") //Left untimed, as it was
+			continue
+		}
+		buf.WriteString("[" + lrcTime(line.StartTimeMs) + "]")
+		if len(line.Segments) == 0 {
+			buf.WriteString(line.Text + "\n")
+			continue
+		}
+		for j := 0; j < len(line.Segments); j++ {
+			buf.WriteString("<" + lrcTime(line.Segments[j].StartTimeMs) + ">" + line.Segments[j].Text)
+		}
+		if last := line.Segments[len(line.Segments)-1]; last.EndTimeMs > 0 {
+			buf.WriteString("<" + lrcTime(last.EndTimeMs) + ">")
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// exportVTT renders the transcript as WebVTT, with cue timestamps for each word or syllable where the transcript has them
+func (obj *ObjectTranscript) exportVTT(cues []*transcriptCue) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("WEBVTT\n")
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	for i := 0; i < len(cues); i++ {
+		cue := cues[i]
+		fmt.Fprintf(buf, "\n%d\n%s --> %s\n", i+1, cueTime(cue.StartTimeMs, "."), cueTime(cue.EndTimeMs, "."))
+		text := ""
+		if len(cue.Segments) > 0 {
+			for j := 0; j < len(cue.Segments); j++ {
+				segment := cue.Segments[j]
+				//Timestamps must fall within the cue, the first usually starts with it
+				if segment.StartTimeMs > cue.StartTimeMs && segment.StartTimeMs < cue.EndTimeMs {
+					text += "<" + cueTime(segment.StartTimeMs, ".") + ">"
+				}
+				text += escaper.Replace(segment.Text)
+			}
+		} else {
+			text = escaper.Replace(cue.Text)
+		}
+		buf.WriteString(obj.cueText(text) + "\n")
+	}
+	return buf.Bytes()
+}
+
+// exportSRT renders the transcript as SubRip
+func (obj *ObjectTranscript) exportSRT(cues []*transcriptCue) []byte {
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(cues); i++ {
+		cue := cues[i]
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "%d\n%s --> %s\n%s\n", i+1, cueTime(cue.StartTimeMs, ","), cueTime(cue.EndTimeMs, ","), obj.cueText(cue.Text))
+	}
+	return buf.Bytes()
+}
+
+// exportTTML renders the transcript as TTML, with a span for each word or syllable where the transcript has them
+func (obj *ObjectTranscript) exportTTML(cues []*transcriptCue) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:tts="http://www.w3.org/ns/ttml#styling"`)
+	if obj.Language != "" {
+		buf.WriteString(` xml:lang="` + ttmlEscape(obj.Language) + `"`)
+	}
+	buf.WriteString(">\n<body")
+	if obj.RightToLeft {
+		buf.WriteString(` tts:direction="rtl" tts:unicodeBidi="embed"`)
+	}
+	buf.WriteString(">\n<div>\n")
+	for i := 0; i < len(cues); i++ {
+		cue := cues[i]
+		if !obj.TimeSynced {
+			//Untimed paragraphs are shown for as long as the document is
+			lines := strings.Split(cue.Text, "\n")
+			for j := 0; j < len(lines); j++ {
+				lines[j] = ttmlEscape(lines[j])
+			}
+			buf.WriteString("<p>" + strings.Join(lines, "<br/>") + "</p>\n")
+			continue
+		}
+		fmt.Fprintf(buf, `<p begin="%s" end="%s">`, cueTime(cue.StartTimeMs, "."), cueTime(cue.EndTimeMs, "."))
+		if len(cue.Segments) == 0 {
+			buf.WriteString(ttmlEscape(cue.Text))
+		}
+		for j := 0; j < len(cue.Segments); j++ {
+			segment := cue.Segments[j]
+			//Times within a paragraph count from when it begins
+			begin := segment.StartTimeMs - cue.StartTimeMs
+			if begin < 0 {
+				begin = 0
+			}
+			buf.WriteString(`<span begin="` + cueTime(begin, ".") + `"`)
+			if segment.EndTimeMs > segment.StartTimeMs {
+				buf.WriteString(` end="` + cueTime(segment.EndTimeMs-cue.StartTimeMs, ".") + `"`)
+			}
+			buf.WriteString(">" + ttmlEscape(segment.Text) + "</span>")
+		}
+		buf.WriteString("</p>\n")
+	}
+	buf.WriteString("</div>\n</body>\n</tt>\n")
+	return buf.Bytes()
+}
+
+// cueText marks each line of a cue as right-to-left if the transcript is
+func (obj *ObjectTranscript) cueText(text string) string {
+	if !obj.RightToLeft {
+		return text
+	}
+	return rightToLeftMark + strings.ReplaceAll(text, "\n", "\n"+rightToLeftMark)
+}
+
+// lrcTime returns milliseconds as an LRC timestamp, ex: 01:23.45
+func lrcTime(timeMs int) string {
+	return fmt.Sprintf("%02d:%02d.%02d", timeMs/60000, timeMs/1000%60, timeMs%1000/10)
+}
+
+// cueTime returns milliseconds as a subtitle timestamp with the given decimal separator, ex: 00:01:23.450
+func cueTime(timeMs int, separator string) string {
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", timeMs/3600000, timeMs/60000%60, timeMs/1000%60, separator, timeMs%1000)
+}
+
+// ttmlEscape returns text escaped for use within TTML
+func ttmlEscape(text string) string {
+	buf := &bytes.Buffer{}
+	xml.EscapeText(buf, []byte(text))
+	return buf.String()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTranscriptExport(t *testing.T) {
+	synced := &ObjectTranscript{Language: "en", TimeSynced: true, SyncType: TranscriptWordSynced, Lines: []*ObjectTranscriptLine{
+		{StartTimeMs: 1000, Text: "One more time", Segments: []*ObjectTranscriptSegment{
+			{StartTimeMs: 1000, EndTimeMs: 1400, Text: "One "},
+			{StartTimeMs: 1400, EndTimeMs: 1800, Text: "more "},
+			{StartTimeMs: 1800, EndTimeMs: 2200, Text: "time"},
+		}},
+		{StartTimeMs: 3000, Text: "We're <gonna> & celebrate"},
+	}}
+	unsynced := &ObjectTranscript{RightToLeft: true, SyncType: TranscriptUnsynced, Lines: []*ObjectTranscriptLine{{Text: "שלום"}, {Text: ""}, {Text: "עולם"}}}
+	ended := &ObjectTranscript{TimeSynced: true, SyncType: TranscriptLineSynced, Lines: []*ObjectTranscriptLine{
+		{StartTimeMs: 1000, EndTimeMs: 1500, Text: "One"},
+		{StartTimeMs: 2000, Text: ""},
+		{StartTimeMs: 0, Text: "(untimed)"},
+		{StartTimeMs: 62000, Text: "Two"},
+	}}
+
+	tests := []struct {
+		name        string
+		transcript  *ObjectTranscript
+		format      string
+		durationMs  int
+		want        string
+		contentType string
+	}{
+		{
+			name:        "synced lrc",
+			transcript:  synced,
+			format:      "lrc",
+			durationMs:  10000,
+			want:        "[la:en]\n[length:00:10]\n[00:01.00]<00:01.00>One <00:01.40>more <00:01.80>time<00:02.20>\n[00:03.00]We're <gonna> & celebrate\n",
+			contentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:        "synced vtt",
+			transcript:  synced,
+			format:      "vtt",
+			durationMs:  10000,
+			want:        "WEBVTT\n\n1\n00:00:01.000 --> 00:00:03.000\nOne <00:00:01.400>more <00:00:01.800>time\n\n2\n00:00:03.000 --> 00:00:10.000\nWe're &lt;gonna&gt; &amp; celebrate\n",
+			contentType: "text/vtt; charset=utf-8",
+		},
+		{
+			name:        "synced srt",
+			transcript:  synced,
+			format:      "srt",
+			durationMs:  10000,
+			want:        "1\n00:00:01,000 --> 00:00:03,000\nOne more time\n\n2\n00:00:03,000 --> 00:00:10,000\nWe're <gonna> & celebrate\n",
+			contentType: "application/x-subrip; charset=utf-8",
+		},
+		{
+			name:       "synced ttml",
+			transcript: synced,
+			format:     "ttml",
+			durationMs: 10000,
+			want: `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:tts="http://www.w3.org/ns/ttml#styling" xml:lang="en">` + "\n<body>\n<div>\n" +
+				`<p begin="00:00:01.000" end="00:00:03.000"><span begin="00:00:00.000" end="00:00:00.400">One </span><span begin="00:00:00.400" end="00:00:00.800">more </span><span begin="00:00:00.800" end="00:00:01.200">time</span></p>` + "\n" +
+				`<p begin="00:00:03.000" end="00:00:10.000">We&#39;re &lt;gonna&gt; &amp; celebrate</p>` + "\n" +
+				"</div>\n</body>\n</tt>\n",
+			contentType: "application/ttml+xml; charset=utf-8",
+		},
+		{
+			name:        "unsynced lrc",
+			transcript:  unsynced,
+			format:      "lrc",
+			want:        "שלום\n\nעולם\n",
+			contentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:        "unsynced right-to-left srt",
+			transcript:  unsynced,
+			format:      "srt",
+			want:        "1\n00:00:00,000 --> 00:00:10,000\n\u200fשלום\n\u200fעולם\n",
+			contentType: "application/x-subrip; charset=utf-8",
+		},
+		{
+			name:        "unsynced right-to-left vtt for the whole stream",
+			transcript:  unsynced,
+			format:      "vtt",
+			durationMs:  180000,
+			want:        "WEBVTT\n\n1\n00:00:00.000 --> 00:03:00.000\n\u200fשלום\n\u200fעולם\n",
+			contentType: "text/vtt; charset=utf-8",
+		},
+		{
+			name:       "unsynced right-to-left ttml",
+			transcript: unsynced,
+			format:     "ttml",
+			want: `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:tts="http://www.w3.org/ns/ttml#styling">` + "\n" +
+				`<body tts:direction="rtl" tts:unicodeBidi="embed">` + "\n<div>\n<p>שלום<br/>עולם</p>\n</div>\n</body>\n</tt>\n",
+			contentType: "application/ttml+xml; charset=utf-8",
+		},
+		{
+			name:        "line ends, empty lines and untimed lines srt",
+			transcript:  ended,
+			format:      "srt",
+			want:        "1\n00:00:01,000 --> 00:00:01,500\nOne\n\n2\n00:00:02,000 --> 00:01:02,000\n(untimed)\n\n3\n00:01:02,000 --> 00:01:07,000\nTwo\n",
+			contentType: "application/x-subrip; charset=utf-8",
+		},
+		{
+			name:        "line ends, empty lines and untimed lines lrc",
+			transcript:  ended,
+			format:      "lrc",
+			want:        "[00:01.00]One\n[00:02.00]\n(untimed)\n[01:02.00]Two\n",
+			contentType: "text/plain; charset=utf-8",
+		},
+	}
+	for _, test := range tests {
+		data, contentType, err := test.transcript.Export(test.format, test.durationMs)
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+			continue
+		}
+		if string(data) != test.want {
+			t.Errorf("%s: exported\n%q\nwant\n%q", test.name, data, test.want)
+		}
+		if contentType != test.contentType {
+			t.Errorf("%s: content type %q, want %q", test.name, contentType, test.contentType)
+		}
+	}
+
+	if _, _, err := synced.Export("ass", 0); err == nil {
+		t.Errorf("exported to an unknown format, want error")
+	} else if objErr, ok := err.(*ObjectError); !ok || objErr.Code != ErrBadURI {
+		t.Errorf("unknown format returned %v, want error %s", err, ErrBadURI)
+	}
+}
+
+func TestTranscriptExportLRCRoundTrip(t *testing.T) {
+	for _, transcript := range []*ObjectTranscript{
+		parseLRC("[00:01.00]<00:01.00>One <00:01.40>more <00:01.80>time <00:02.20>\n[00:03.00]Celebrate"),
+		parseLRC("[00:01.00]<00:01.00>Hel<00:01.50>lo <00:02.00>world <00:02.50>"),
+		parseLRC("[01:02.03]One more time\n(untimed)\n[01:05.00]Celebrate"),
+	} {
+		data, _, err := transcript.Export("lrc", 0)
+		if err != nil {
+			t.Errorf("failed to export %s: %v", mustJSON(t, transcript), err)
+			continue
+		}
+		if again := parseLRC(string(data)); !reflect.DeepEqual(again, transcript) {
+			t.Errorf("LRC didn't round trip through %q:\n got %s\nwant %s", data, mustJSON(t, again), mustJSON(t, transcript))
+		}
+	}
+}
+
+func TestTranscriptTimes(t *testing.T) {
+	tests := []struct {
+		timeMs int
+		lrc    string
+		vtt    string
+		srt    string
+	}{
+		{timeMs: 0, lrc: "00:00.00", vtt: "00:00:00.000", srt: "00:00:00,000"},
+		{timeMs: 1234, lrc: "00:01.23", vtt: "00:00:01.234", srt: "00:00:01,234"},
+		{timeMs: 83450, lrc: "01:23.45", vtt: "00:01:23.450", srt: "00:01:23,450"},
+		{timeMs: 3723004, lrc: "62:03.00", vtt: "01:02:03.004", srt: "01:02:03,004"},
+	}
+	for _, test := range tests {
+		if got := lrcTime(test.timeMs); got != test.lrc {
+			t.Errorf("lrcTime(%d) = %q, want %q", test.timeMs, got, test.lrc)
+		}
+		if got := cueTime(test.timeMs, "."); got != test.vtt {
+			t.Errorf("cueTime(%d, \".\") = %q, want %q", test.timeMs, got, test.vtt)
+		}
+		if got := cueTime(test.timeMs, ","); got != test.srt {
+			t.Errorf("cueTime(%d, \",\") = %q, want %q", test.timeMs, got, test.srt)
+		}
+		if back, ok := parseLRCTime(test.lrc); !ok || back != test.timeMs/10*10 {
+			t.Errorf("parseLRCTime(lrcTime(%d)) = %d, %v", test.timeMs, back, ok)
+		}
+	}
+}
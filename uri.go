@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+/* libremedia URI grammar:
+
+uri      = object [ ":" sub *( ":" arg ) ] [ "?" params ]
+object   = provider ":" type ":" id    ; ex: tidal:track:12345
+         / namespace ":" query         ; ex: search:daft punk, the rest of the URI is the query
+         / namespace ":" id            ; ex: isrc:USUM71703861
+params   = key "=" value *( "&" key "=" value ) ; ex: ?window=week for charts, or ?providers=spotify,tidal for queries
+
+Every component is percent-escaped, so a literal ":" is %3A, "?" is %3F, "/" is %2F and "%" is %25.
+Queries also treat "+" as a space, so a literal "+" in a query is %2B. Queries are case insensitive.
+Components other than queries can't be empty, "." or "..", or hold a "/" or "\\" once unescaped, as they name cache paths.
+Params are part of the cache key, so only those the URI's kind of object uses are kept: providers for queries, window for
+charts, type for artworks and granularity for transcripts.
+*/
+
+var (
+	//Namespaces where everything after the first colon is a single query
+	uriQueries = map[string]bool{
+		"search":    true,
+		"bestmatch": true,
+	}
+
+	uriEscaper      = strings.NewReplacer("%", "%25", ":", "%3A", "?", "%3F", "/", "%2F", "#", "%23", "\\", "%5C")
+	uriQueryEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "?", "%3F", "/", "%2F", "#", "%23", "\\", "%5C", "+", "%2B")
+)
+
+// URI holds a parsed libremedia URI
+type URI struct {
+	Provider string     //The provider or namespace of this URI, ex: tidal, spotify, search
+	Type     string     //The type of object, ex: track, album, artist
+	ID       string     //The ID of the object, or the query for query namespaces
+	Sub      string     //The sub-resource of the object, ex: artwork, transcript
+	SubArgs  []string   //The arguments for the sub-resource, ex: 1280 for artwork
+	Params   url.Values //Optional parameters, ex: limit=10
+}
+
+// NewURI returns a URI that refers to an object
+func NewURI(provider, objType, id string) *URI {
+	return &URI{Provider: provider, Type: objType, ID: id, Params: url.Values{}}
+}
+
+// NewQueryURI returns a URI that refers to a query within a namespace, like search or bestmatch
+func NewQueryURI(namespace, query string) *URI {
+	return &URI{Provider: namespace, ID: normalizeQuery(query), Params: url.Values{}}
+}
+
+// ParseURI parses a raw libremedia URI
+func ParseURI(raw string) (*URI, error) {
+	if raw == "" {
+		return nil, NewError(ErrBadURI, "", "empty uri")
+	}
+	uri := &URI{Params: url.Values{}}
+
+	path := raw
+	if i := strings.Index(raw, "?"); i > -1 {
+		path = raw[:i]
+		params, err := url.ParseQuery(raw[i+1:])
+		if err != nil {
+			return nil, NewError(ErrBadURI, "", "invalid params in uri %s: %v", raw, err)
+		}
+		uri.Params = params
+	}
+
+	segments := strings.Split(path, ":")
+	uri.Provider = unescapeURIComponent(segments[0])
+	if uri.Provider == "" {
+		return nil, NewError(ErrBadURI, "", "no provider in uri %s", raw)
+	}
+	if uriQueries[uri.Provider] {
+		query := strings.Join(segments[1:], ":")
+		query = strings.ReplaceAll(query, "+", " ")
+		uri.ID = normalizeQuery(unescapeURIComponent(query))
+		//Normalize the provider filter, since it's part of the cache key
+		if filter := searchFilter(uri.Params); len(filter) > 0 {
+			uri.Params.Set("providers", strings.Join(filter, ","))
+		} else {
+			uri.Params.Del("providers")
+		}
+		uri.filterParams()
+		return uri, nil
+	}
+
+	for i := 0; i < len(segments); i++ {
+		if err := checkURIComponent(unescapeURIComponent(segments[i])); err != nil {
+			return nil, NewError(ErrBadURI, "", "invalid uri %s: %v", raw, err)
+		}
+	}
+
+	switch len(segments) {
+	case 1:
+	case 2:
+		uri.ID = unescapeURIComponent(segments[1])
+	default:
+		uri.Type = unescapeURIComponent(segments[1])
+		uri.ID = unescapeURIComponent(segments[2])
+		if len(segments) > 3 {
+			uri.Sub = unescapeURIComponent(segments[3])
+			for i := 4; i < len(segments); i++ {
+				uri.SubArgs = append(uri.SubArgs, unescapeURIComponent(segments[i]))
+			}
+		}
+	}
+	uri.filterParams()
+	return uri, nil
+}
+
+// AllowsParam returns true if this URI's kind of object uses a param, so it belongs in the cache key
+func (u *URI) AllowsParam(key string) bool {
+	switch {
+	case u.IsQuery():
+		return key == "providers"
+	case u.Provider == "charts":
+		return key == "window"
+	case u.Sub == "artwork":
+		return key == "type"
+	case u.Sub == "transcript":
+		return key == "granularity"
+	}
+	return false
+}
+
+// SetParams copies the params this URI's kind of object uses, ex: from the query of a request
+func (u *URI) SetParams(params url.Values) {
+	for key, values := range params {
+		if u.AllowsParam(key) {
+			u.Params[key] = values
+		}
+	}
+}
+
+// filterParams drops any params this URI's kind of object doesn't use, so they can't split the cache
+func (u *URI) filterParams() {
+	for key := range u.Params {
+		if !u.AllowsParam(key) {
+			delete(u.Params, key)
+		}
+	}
+}
+
+// IsQuery returns true if this URI holds a query instead of an object ID
+func (u *URI) IsQuery() bool {
+	return uriQueries[u.Provider]
+}
+
+// Base returns a copy of this URI that refers to the object itself, without any sub-resource or params
+func (u *URI) Base() *URI {
+	return &URI{Provider: u.Provider, Type: u.Type, ID: u.ID, Params: url.Values{}}
+}
+
+// segments returns each escaped component of this URI, without the params
+func (u *URI) segments() []string {
+	if u.IsQuery() {
+		return []string{uriEscaper.Replace(u.Provider), uriQueryEscaper.Replace(u.ID)}
+	}
+	segments := []string{uriEscaper.Replace(u.Provider)}
+	if u.Type != "" {
+		segments = append(segments, uriEscaper.Replace(u.Type))
+	}
+	if u.ID != "" {
+		segments = append(segments, uriEscaper.Replace(u.ID))
+	}
+	if u.Sub != "" {
+		segments = append(segments, uriEscaper.Replace(u.Sub))
+		for i := 0; i < len(u.SubArgs); i++ {
+			segments = append(segments, uriEscaper.Replace(u.SubArgs[i]))
+		}
+	}
+	return segments
+}
+
+// String returns this URI in its canonical escaped form
+func (u *URI) String() string {
+	uri := strings.Join(u.segments(), ":")
+	if len(u.Params) > 0 {
+		uri += "?" + u.Params.Encode()
+	}
+	return uri
+}
+
+// Path returns the path to the cache file of this URI
+func (u *URI) Path() string {
+	segments := u.segments()
+	fileName := segments[len(segments)-1]
+	if len(u.Params) > 0 {
+		fileName += uriEscaper.Replace("?" + u.Params.Encode())
+	}
+	pathURL := "cache/"
+	for i := 0; i < len(segments)-1; i++ {
+		pathURL += uriPathEscape(segments[i]) + "/"
+	}
+	return pathURL + fileName + ".json"
+}
+
+// uriPathEscape escapes a component that would otherwise refer to the current or parent directory within a path
+func uriPathEscape(segment string) string {
+	if segment == "." || segment == ".." {
+		return strings.ReplaceAll(segment, ".", "%2E")
+	}
+	return segment
+}
+
+// checkURIComponent returns an error if an unescaped component can't be used as part of a cache path
+func checkURIComponent(component string) error {
+	switch component {
+	case "":
+		return NewError(ErrBadURI, "", "empty component")
+	case ".", "..":
+		return NewError(ErrBadURI, "", "component %s isn't allowed", component)
+	}
+	if strings.ContainsAny(component, "/\\") {
+		return NewError(ErrBadURI, "", "component %s holds a path separator", component)
+	}
+	return nil
+}
+
+// normalizeQuery returns a query in the form used for caching
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
+
+// unescapeURIComponent returns the unescaped form of a URI component, or the component itself if it wasn't escaped properly
+func unescapeURIComponent(component string) string {
+	if unescaped, err := url.PathUnescape(component); err == nil {
+		return unescaped
+	}
+	return component
+}
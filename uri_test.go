@@ -0,0 +1,167 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    *URI
+		str     string
+		path    string
+		wantErr bool
+	}{
+		{
+			raw:  "tidal:track:12345",
+			want: &URI{Provider: "tidal", Type: "track", ID: "12345"},
+			str:  "tidal:track:12345",
+			path: "cache/tidal/track/12345.json",
+		},
+		{
+			raw:  "spotify:album:abc:artwork:1280",
+			want: &URI{Provider: "spotify", Type: "album", ID: "abc", Sub: "artwork", SubArgs: []string{"1280"}},
+			str:  "spotify:album:abc:artwork:1280",
+			path: "cache/spotify/album/abc/artwork/1280.json",
+		},
+		{
+			raw:  "isrc:USUM71703861",
+			want: &URI{Provider: "isrc", ID: "USUM71703861"},
+			str:  "isrc:USUM71703861",
+			path: "cache/isrc/USUM71703861.json",
+		},
+		{
+			raw:  "charts:streams?window=week",
+			want: &URI{Provider: "charts", ID: "streams", Params: map[string][]string{"window": {"week"}}},
+			str:  "charts:streams?window=week",
+			path: "cache/charts/streams%3Fwindow=week.json",
+		},
+		{
+			raw:  "search:Daft+Punk:%2B1",
+			want: &URI{Provider: "search", ID: "daft punk:+1"},
+			str:  "search:daft punk%3A%2B1",
+			path: "cache/search/daft punk%3A%2B1.json",
+		},
+		{
+			raw:  "search:ac%2Fdc?providers=Tidal,spotify,tidal",
+			want: &URI{Provider: "search", ID: "ac/dc", Params: map[string][]string{"providers": {"spotify,tidal"}}},
+			str:  "search:ac%2Fdc?providers=spotify%2Ctidal",
+			path: "cache/search/ac%2Fdc%3Fproviders=spotify%252Ctidal.json",
+		},
+		{
+			raw:  "search:..",
+			want: &URI{Provider: "search", ID: ".."},
+			str:  "search:..",
+			path: "cache/search/...json",
+		},
+		{
+			raw:  "tidal:track:a%3Ab",
+			want: &URI{Provider: "tidal", Type: "track", ID: "a:b"},
+			str:  "tidal:track:a%3Ab",
+			path: "cache/tidal/track/a%3Ab.json",
+		},
+		{
+			raw:  "tidal:track:1?x=1&limit=10",
+			want: &URI{Provider: "tidal", Type: "track", ID: "1"},
+			str:  "tidal:track:1",
+			path: "cache/tidal/track/1.json",
+		},
+		{
+			raw:  "tidal:album:abc:artwork:640?type=mp4&granularity=word",
+			want: &URI{Provider: "tidal", Type: "album", ID: "abc", Sub: "artwork", SubArgs: []string{"640"}, Params: map[string][]string{"type": {"mp4"}}},
+			str:  "tidal:album:abc:artwork:640?type=mp4",
+			path: "cache/tidal/album/abc/artwork/640%3Ftype=mp4.json",
+		},
+		{
+			raw:  "search:daft punk?providers=tidal&limit=10",
+			want: &URI{Provider: "search", ID: "daft punk", Params: map[string][]string{"providers": {"tidal"}}},
+			str:  "search:daft punk?providers=tidal",
+			path: "cache/search/daft punk%3Fproviders=tidal.json",
+		},
+		{raw: "", wantErr: true},
+		{raw: ":track:1", wantErr: true},
+		{raw: "tidal::1", wantErr: true},
+		{raw: "tidal:track:", wantErr: true},
+		{raw: "tidal:..:..:x", wantErr: true},
+		{raw: "tidal:%2E%2E:%2E%2E:x", wantErr: true},
+		{raw: "tidal:.:1", wantErr: true},
+		{raw: "tidal:track:a%2Fb", wantErr: true},
+		{raw: "tidal:track:a%5Cb", wantErr: true},
+		{raw: "tidal:track:1?%zz", wantErr: true},
+	}
+	for _, test := range tests {
+		uri, err := ParseURI(test.raw)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseURI(%q) = %+v, want error", test.raw, uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseURI(%q) returned error: %v", test.raw, err)
+			continue
+		}
+		if test.want.Params == nil {
+			test.want.Params = map[string][]string{}
+		}
+		if !reflect.DeepEqual(uri, test.want) {
+			t.Errorf("ParseURI(%q) = %+v, want %+v", test.raw, uri, test.want)
+		}
+		if got := uri.String(); got != test.str {
+			t.Errorf("ParseURI(%q).String() = %q, want %q", test.raw, got, test.str)
+		}
+		if got := uri.Path(); got != test.path {
+			t.Errorf("ParseURI(%q).Path() = %q, want %q", test.raw, got, test.path)
+		}
+
+		//The canonical form must parse back to the same URI
+		again, err := ParseURI(uri.String())
+		if err != nil || !reflect.DeepEqual(again, uri) {
+			t.Errorf("ParseURI(%q) doesn't round trip through %q: %+v, %v", test.raw, uri.String(), again, err)
+		}
+	}
+}
+
+func TestURIPathStaysInCache(t *testing.T) {
+	uris := []*URI{
+		NewURI("..", "..", "x"),
+		NewURI(".", "track", "1"),
+		NewURI("tidal", "../..", "x"),
+		{Provider: "tidal", Type: "track", ID: "1", Sub: "..", SubArgs: []string{"..", "x"}},
+	}
+	for _, uri := range uris {
+		path := uri.Path()
+		for _, element := range strings.Split(path, "/") {
+			if element == "." || element == ".." {
+				t.Errorf("%+v has cache path %q, which leaves the cache", uri, path)
+			}
+		}
+	}
+}
+
+func TestURISetParams(t *testing.T) {
+	query := map[string][]string{"accessKey": {"secret"}, "serve": {"proxy"}, "window": {"day"}, "type": {"mp4"}, "granularity": {"word"}, "providers": {"tidal"}, "x": {"1"}}
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "tidal:track:1", want: "tidal:track:1"},
+		{raw: "charts:streams", want: "charts:streams?window=day"},
+		{raw: "tidal:track:1:artwork", want: "tidal:track:1:artwork?type=mp4"},
+		{raw: "tidal:track:1:transcript", want: "tidal:track:1:transcript?granularity=word"},
+		{raw: "bestmatch:daft punk", want: "bestmatch:daft punk?providers=tidal"},
+	}
+	for _, test := range tests {
+		uri, err := ParseURI(test.raw)
+		if err != nil {
+			t.Errorf("ParseURI(%q) returned error: %v", test.raw, err)
+			continue
+		}
+		uri.SetParams(query)
+		if got := uri.String(); got != test.want {
+			t.Errorf("%s with the params of a request = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}